@@ -1,9 +1,11 @@
 package cron
 
 import (
+	"fmt"
 	"github.com/open-falcon/agent/g"
 	"github.com/open-falcon/common/model"
 	"log"
+	"net"
 	"strconv"
 	"strings"
 	"time"
@@ -25,10 +27,15 @@ func syncBuiltinMetrics() {
 	for {
 		time.Sleep(duration)
 
-		var ports = []int64{}
+		var ports = []*g.PortListen{}
 		var paths = []string{}
-		var procs = make(map[string]map[int]string)
-		var urls = make(map[string]string)
+		var procTags = []string{}
+		var certTags = []string{}
+		var tcpTags = []string{}
+		var dnsTags = []string{}
+		var pingTags = []string{}
+		var fileTags = []string{}
+		var urls = []*g.UrlCheck{}
 
 		hostname, err := g.Hostname()
 		if err != nil {
@@ -58,38 +65,36 @@ func syncBuiltinMetrics() {
 		timestamp = resp.Timestamp
 		checksum = resp.Checksum
 
+		// metric => tags => error
+		errs := make(map[string]map[string]string)
+		addErrors := func(metric string, m map[string]string) {
+			for tags, err := range m {
+				log.Println("invalid", metric, tags, ":", err)
+			}
+			if len(m) > 0 {
+				errs[metric] = m
+			}
+		}
+
+		urlErrors := make(map[string]string)
+		portErrors := make(map[string]string)
 		for _, metric := range resp.Metrics {
 
 			if metric.Metric == g.URL_CHECK_HEALTH {
-				arr := strings.Split(metric.Tags, ",")
-				if len(arr) != 2 {
-					continue
-				}
-				url := strings.Split(arr[0], "=")
-				if len(url) != 2 {
-					continue
-				}
-				stime := strings.Split(arr[1], "=")
-				if len(stime) != 2 {
-					continue
-				}
-				if _, err := strconv.ParseInt(stime[1], 10, 64); err == nil {
-					urls[url[1]] = stime[1]
+				if check, err := g.ParseUrlCheck(metric.Tags); err == nil {
+					urls = append(urls, check)
 				} else {
-					log.Println("metric ParseInt timeout failed:", err)
+					urlErrors[metric.Tags] = err.Error()
 				}
+
+				continue
 			}
 
 			if metric.Metric == g.NET_PORT_LISTEN {
-				arr := strings.Split(metric.Tags, "=")
-				if len(arr) != 2 {
-					continue
-				}
-
-				if port, err := strconv.ParseInt(arr[1], 10, 64); err == nil {
+				if port, err := parsePortListen(metric.Tags); err == nil {
 					ports = append(ports, port)
 				} else {
-					log.Println("metrics ParseInt failed:", err)
+					portErrors[metric.Tags] = err.Error()
 				}
 
 				continue
@@ -106,26 +111,90 @@ func syncBuiltinMetrics() {
 			}
 
 			if metric.Metric == g.PROC_NUM {
-				arr := strings.Split(metric.Tags, ",")
+				procTags = append(procTags, metric.Tags)
+				continue
+			}
 
-				tmpMap := make(map[int]string)
+			if metric.Metric == g.CERT_EXPIRE_DAYS {
+				certTags = append(certTags, metric.Tags)
+				continue
+			}
 
-				for i := 0; i < len(arr); i++ {
-					if strings.HasPrefix(arr[i], "name=") {
-						tmpMap[1] = strings.TrimSpace(arr[i][5:])
-					} else if strings.HasPrefix(arr[i], "cmdline=") {
-						tmpMap[2] = strings.TrimSpace(arr[i][8:])
-					}
-				}
+			if metric.Metric == g.NET_TCP_CONNECT {
+				tcpTags = append(tcpTags, metric.Tags)
+				continue
+			}
 
-				procs[metric.Tags] = tmpMap
+			if metric.Metric == g.DNS_RESOLVE {
+				dnsTags = append(dnsTags, metric.Tags)
+				continue
+			}
+
+			if metric.Metric == g.NET_PING_LOSS {
+				pingTags = append(pingTags, metric.Tags)
+				continue
+			}
+
+			if metric.Metric == g.FILE_EXISTS {
+				fileTags = append(fileTags, metric.Tags)
 			}
 		}
+		addErrors(g.URL_CHECK_HEALTH, urlErrors)
+		addErrors(g.NET_PORT_LISTEN, portErrors)
+
+		procs, procErrors := g.ParseProcMatchers(procTags)
+		addErrors(g.PROC_NUM, procErrors)
+		certs, certErrors := g.ParseCertChecks(certTags)
+		addErrors(g.CERT_EXPIRE_DAYS, certErrors)
+		tcps, tcpErrors := g.ParseTcpChecks(tcpTags)
+		addErrors(g.NET_TCP_CONNECT, tcpErrors)
+		dnss, dnsErrors := g.ParseDnsChecks(dnsTags)
+		addErrors(g.DNS_RESOLVE, dnsErrors)
+		pings, pingErrors := g.ParsePingChecks(pingTags)
+		addErrors(g.NET_PING_LOSS, pingErrors)
+		files, fileErrors := g.ParseFileChecks(fileTags)
+		addErrors(g.FILE_EXISTS, fileErrors)
 
 		g.SetReportUrls(urls)
 		g.SetReportPorts(ports)
 		g.SetReportProcs(procs)
 		g.SetDuPaths(paths)
+		g.SetReportCerts(certs)
+		g.SetReportNetChecks(tcps, dnss)
+		g.SetReportPings(pings)
+		g.SetReportFiles(files)
+		g.SetReportErrors(errs)
+
+	}
+}
+
+// parsePortListen parses tags of net.port.listen: port=N[,proto=tcp|tcp6|udp|udp6][,addr=ip]
+func parsePortListen(tags string) (*g.PortListen, error) {
+	m, err := g.ParseTags(tags, "port", "proto", "addr")
+	if err != nil {
+		return nil, err
+	}
+
+	p := &g.PortListen{Tags: strings.TrimSpace(tags), Proto: "tcp", Addr: m["addr"]}
+
+	val, ok := m["port"]
+	if !ok {
+		return nil, fmt.Errorf("no port")
+	}
+	p.Port, err = strconv.ParseInt(val, 10, 64)
+	if err != nil || p.Port <= 0 || p.Port > 65535 {
+		return nil, fmt.Errorf("bad port %s", val)
+	}
+
+	if val, ok := m["proto"]; ok {
+		if val != "tcp" && val != "tcp6" && val != "udp" && val != "udp6" {
+			return nil, fmt.Errorf("bad proto %s", val)
+		}
+		p.Proto = val
+	}
 
+	if p.Addr != "" && net.ParseIP(p.Addr) == nil {
+		return nil, fmt.Errorf("bad addr %s", p.Addr)
 	}
+	return p, nil
 }
@@ -69,14 +69,20 @@ func syncMinePlugins() {
 		}
 
 		desiredAll := make(map[string]*plugins.Plugin)
+		manifestErrors := make(map[string]string)
 
 		for _, p := range pluginDirs {
-			underOneDir := plugins.ListPlugins(strings.Trim(p, "/"))
+			underOneDir, errs := plugins.ListPlugins(strings.Trim(p, "/"))
 			for k, v := range underOneDir {
 				desiredAll[k] = v
 			}
+			for k, v := range errs {
+				manifestErrors[k] = v
+			}
 		}
 
+		plugins.SetManifestErrors(manifestErrors)
+
 		plugins.DelNoUsePlugins(desiredAll)
 		plugins.AddNewPlugins(desiredAll)
 
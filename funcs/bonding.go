@@ -0,0 +1,146 @@
+package funcs
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/open-falcon/common/model"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const bondingDir = "/proc/net/bonding"
+
+type BondSlave struct {
+	Iface        string
+	Up           bool
+	Active       bool
+	LinkFailures uint64
+	AggregatorId string
+}
+
+type Bond struct {
+	Name         string
+	Mode         string
+	Up           bool
+	ActiveSlave  string
+	AggregatorId string
+	Slaves       []*BondSlave
+}
+
+// ReadBond parses /proc/net/bonding/$bond
+func ReadBond(name string) (*Bond, error) {
+	f, err := os.Open(filepath.Join(bondingDir, name))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	bond := &Bond{Name: name}
+	var slave *BondSlave
+	inActiveAggregator := false
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		idx := strings.Index(line, ":")
+		if idx < 0 {
+			continue
+		}
+		key := strings.TrimSpace(line[:idx])
+		val := strings.TrimSpace(line[idx+1:])
+
+		switch key {
+		case "Bonding Mode":
+			bond.Mode = val
+		case "Currently Active Slave":
+			bond.ActiveSlave = val
+		case "Active Aggregator Info":
+			inActiveAggregator = true
+		case "Slave Interface":
+			slave = &BondSlave{Iface: val}
+			bond.Slaves = append(bond.Slaves, slave)
+		case "MII Status":
+			if slave == nil {
+				bond.Up = val == "up"
+			} else {
+				slave.Up = val == "up"
+			}
+		case "Link Failure Count":
+			if slave != nil {
+				slave.LinkFailures, _ = strconv.ParseUint(val, 10, 64)
+			}
+		case "Aggregator ID":
+			if slave != nil {
+				slave.AggregatorId = val
+			} else if inActiveAggregator {
+				bond.AggregatorId = val
+				inActiveAggregator = false
+			}
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, s := range bond.Slaves {
+		switch {
+		case bond.ActiveSlave != "":
+			// active-backup
+			s.Active = s.Up && s.Iface == bond.ActiveSlave
+		case bond.AggregatorId != "":
+			// 802.3ad, a slave out of the active aggregator carries no traffic
+			s.Active = s.Up && s.AggregatorId == bond.AggregatorId
+		default:
+			s.Active = s.Up
+		}
+	}
+
+	return bond, nil
+}
+
+// BondingMetrics reports the status of every bond and of its slaves
+func BondingMetrics() (L []*model.MetricValue) {
+	fs, err := ioutil.ReadDir(bondingDir)
+	if err != nil {
+		// bonding module not loaded
+		return
+	}
+
+	for _, f := range fs {
+		bond, err := ReadBond(f.Name())
+		if err != nil {
+			log.Println("read bond", f.Name(), "fail:", err)
+			continue
+		}
+
+		bondTag := "bond=" + bond.Name
+		slavesUp, slavesActive := 0, 0
+		for _, s := range bond.Slaves {
+			tags := fmt.Sprintf("bond=%s,iface=%s", bond.Name, s.Iface)
+			L = append(L, GaugeValue("net.bond.slave.up", boolToInt(s.Up), tags))
+			L = append(L, GaugeValue("net.bond.slave.active", boolToInt(s.Active), tags))
+			L = append(L, CounterValue("net.bond.slave.link_failures", s.LinkFailures, tags))
+			slavesUp += boolToInt(s.Up)
+			slavesActive += boolToInt(s.Active)
+		}
+
+		L = append(L, GaugeValue("net.bond.up", boolToInt(bond.Up), bondTag))
+		L = append(L, GaugeValue("net.bond.slaves", len(bond.Slaves), bondTag))
+		L = append(L, GaugeValue("net.bond.slaves.up", slavesUp, bondTag))
+		L = append(L, GaugeValue("net.bond.slaves.active", slavesActive, bondTag))
+	}
+
+	return
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,190 @@
+package funcs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"time"
+
+	"github.com/open-falcon/agent/g"
+	"github.com/open-falcon/common/model"
+)
+
+// at most so many certificates are checked at the same time
+const certCheckWorkers = 4
+
+// what can be wrong with a certificate, each one is reported as
+// cert.problem with tag reason, 1 if it is the case
+var certProblems = []string{"unreachable", "expired", "unknown_authority", "invalid", "hostname_mismatch"}
+
+// CertResult is what is found checking a CertCheck
+type CertResult struct {
+	Leaf *x509.Certificate
+	// the certificate expiring first, leaf included
+	Earliest *x509.Certificate
+	Problems map[string]bool
+}
+
+// AllCertChecks are the cert.expire.days of collector.certs and of hbs
+func AllCertChecks() map[string]*g.CertCheck {
+	local := g.LocalCerts()
+	remote := g.ReportCerts()
+
+	ret := make(map[string]*g.CertCheck, len(local)+len(remote))
+	for tags, c := range local {
+		ret[tags] = c
+	}
+	for tags, c := range remote {
+		ret[tags] = c
+	}
+	return ret
+}
+
+func CertMetrics() (L []*model.MetricValue) {
+	checks := []*g.CertCheck{}
+	for _, c := range AllCertChecks() {
+		checks = append(checks, c)
+	}
+	sz := len(checks)
+	if sz == 0 {
+		return
+	}
+
+	results := make([]*CertResult, sz)
+	runParallel(sz, certCheckWorkers, func(i int) {
+		results[i] = CheckCert(checks[i])
+	})
+
+	now := time.Now()
+	for i, c := range checks {
+		r := results[i]
+		if r.Leaf != nil {
+			L = append(L, GaugeValue(g.CERT_EXPIRE_DAYS, r.Leaf.NotAfter.Sub(now).Hours()/24, c.Tags))
+			L = append(L, GaugeValue("cert.expire.days.min", r.Earliest.NotAfter.Sub(now).Hours()/24, c.Tags))
+			L = append(L, GaugeValue("cert.chain.valid", boolToInt(!r.Problems["expired"] && !r.Problems["unknown_authority"] && !r.Problems["invalid"]), c.Tags))
+			if c.ServerName != "" {
+				L = append(L, GaugeValue("cert.hostname.valid", boolToInt(!r.Problems["hostname_mismatch"]), c.Tags))
+			}
+		}
+
+		for _, problem := range certProblems {
+			L = append(L, GaugeValue("cert.problem", boolToInt(r.Problems[problem]), c.Tags+",reason="+problem))
+		}
+	}
+	return
+}
+
+// CheckCert gets the certificates of a check, and verifies them against
+// the system roots
+func CheckCert(c *g.CertCheck) *CertResult {
+	r := &CertResult{Problems: make(map[string]bool)}
+
+	var certs []*x509.Certificate
+	var err error
+	if c.Addr != "" {
+		certs, err = peerCertificates(c)
+	} else {
+		certs, err = readPemCertificates(c.File)
+	}
+	if err != nil {
+		log.Println("check cert", c.Tags, "failed:", err)
+		r.Problems["unreachable"] = true
+		return r
+	}
+
+	r.Leaf = certs[0]
+	r.Earliest = certs[0]
+	for _, cert := range certs[1:] {
+		if cert.NotAfter.Before(r.Earliest.NotAfter) {
+			r.Earliest = cert
+		}
+	}
+
+	now := time.Now()
+	for _, cert := range certs {
+		if now.After(cert.NotAfter) || now.Before(cert.NotBefore) {
+			r.Problems["expired"] = true
+		}
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err = r.Leaf.Verify(x509.VerifyOptions{Intermediates: intermediates, CurrentTime: now})
+	switch e := err.(type) {
+	case nil:
+	case x509.UnknownAuthorityError:
+		r.Problems["unknown_authority"] = true
+	case x509.CertificateInvalidError:
+		if e.Reason == x509.Expired {
+			r.Problems["expired"] = true
+		} else {
+			r.Problems["invalid"] = true
+		}
+	default:
+		r.Problems["invalid"] = true
+	}
+
+	if c.ServerName != "" && r.Leaf.VerifyHostname(c.ServerName) != nil {
+		r.Problems["hostname_mismatch"] = true
+	}
+
+	return r
+}
+
+// peerCertificates are the certificates the server presents, verified by
+// CheckCert, not by the handshake, so that a bad one is still reported
+func peerCertificates(c *g.CertCheck) ([]*x509.Certificate, error) {
+	dialer := &net.Dialer{Timeout: time.Duration(c.Timeout) * time.Second}
+	conn, err := tls.DialWithDialer(dialer, "tcp", c.Addr, &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificate")
+	}
+	return certs, nil
+}
+
+// readPemCertificates reads the certificates of a pem file, the first one
+// is the leaf
+func readPemCertificates(path string) ([]*x509.Certificate, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, bs = pem.Decode(bs)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificate in %s", path)
+	}
+	return certs, nil
+}
@@ -3,7 +3,7 @@ package funcs
 import (
 	"fmt"
 	"github.com/toolkits/nux"
-	"github.com/toolkits/sys"
+	"syscall"
 )
 
 func CheckCollector() {
@@ -12,11 +12,9 @@ func CheckCollector() {
 
 	_, procStatErr := nux.CurrentProcStat()
 	_, listDiskErr := nux.ListDiskStats()
-	ports, listeningPortsErr := nux.ListeningPorts()
+	sockets, listSocketsErr := ListSockets(syscall.AF_INET, syscall.IPPROTO_TCP)
 	procs, psErr := nux.AllProcs()
 
-	_, duErr := sys.CmdOut("du", "--help")
-
 	output["kernel  "] = len(KernelMetrics()) > 0
 	output["df.bytes"] = len(DeviceMetrics()) > 0
 	output["net.if  "] = len(CoreNetMetrics([]string{})) > 0
@@ -25,10 +23,9 @@ func CheckCollector() {
 	output["disk.io "] = listDiskErr == nil
 	output["memory  "] = len(MemMetrics()) > 0
 	output["netstat "] = len(NetstatMetrics()) > 0
-	output["ss -s   "] = len(SocketStatSummaryMetrics()) > 0
-	output["ss -tln "] = listeningPortsErr == nil && len(ports) > 0
+	output["sockstat"] = len(SocketStatSummaryMetrics()) > 0
+	output["sockets "] = listSocketsErr == nil && len(sockets) > 0
 	output["ps aux  "] = psErr == nil && len(procs) > 0
-	output["du -bs  "] = duErr == nil
 
 	for k, v := range output {
 		status := "fail"
@@ -0,0 +1,108 @@
+package funcs
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/open-falcon/common/model"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	conntrackCountFile = "/proc/sys/net/netfilter/nf_conntrack_count"
+	conntrackMaxFile   = "/proc/sys/net/netfilter/nf_conntrack_max"
+	conntrackStatFile  = "/proc/net/stat/nf_conntrack"
+)
+
+// columns of /proc/net/stat/nf_conntrack to report per cpu
+var conntrackStats = []string{"drop", "insert_failed", "early_drop"}
+
+func readUintFile(path string) (uint64, error) {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(bs)), 10, 64)
+}
+
+// ConntrackMetrics reports the usage of the conntrack table. It reports
+// nothing if nf_conntrack is not loaded.
+func ConntrackMetrics() (L []*model.MetricValue) {
+	if _, err := os.Stat(conntrackCountFile); err != nil {
+		return
+	}
+
+	count, err := readUintFile(conntrackCountFile)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	max, err := readUintFile(conntrackMaxFile)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	L = append(L, GaugeValue("nf_conntrack.count", count))
+	L = append(L, GaugeValue("nf_conntrack.max", max))
+	if max > 0 {
+		L = append(L, GaugeValue("nf_conntrack.used.percent", float64(count)*100.0/float64(max)))
+	}
+
+	stats, err := ReadConntrackStat()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	for _, name := range conntrackStats {
+		var total uint64
+		for cpu, m := range stats {
+			v, ok := m[name]
+			if !ok {
+				continue
+			}
+			total += v
+			L = append(L, CounterValue("nf_conntrack."+name, v, fmt.Sprintf("cpu=%d", cpu)))
+		}
+		L = append(L, CounterValue("nf_conntrack."+name, total))
+	}
+
+	return
+}
+
+// ReadConntrackStat parses /proc/net/stat/nf_conntrack, a header of column
+// names followed by one line of hex values per cpu
+func ReadConntrackStat() ([]map[string]uint64, error) {
+	f, err := os.Open(conntrackStatFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		return nil, fmt.Errorf("%s is blank", conntrackStatFile)
+	}
+	header := strings.Fields(scanner.Text())
+
+	var ret []map[string]uint64
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != len(header) {
+			continue
+		}
+
+		m := make(map[string]uint64, len(header))
+		for i, name := range header {
+			m[name], _ = strconv.ParseUint(fields[i], 16, 64)
+		}
+		ret = append(ret, m)
+	}
+
+	return ret, scanner.Err()
+}
@@ -13,6 +13,9 @@ const (
 var (
 	procStatHistory [historyCount]*nux.ProcStat
 	psLock          = new(sync.RWMutex)
+
+	// sample taken by the last CpuMetrics call
+	procStatReported *nux.ProcStat
 )
 
 func UpdateCpuStat() error {
@@ -149,22 +152,41 @@ func CpuPrepared() bool {
 	return procStatHistory[1] != nil
 }
 
+// cpuPercent is the share of f in total cpu time between two samples
+func cpuPercent(curr, prev *nux.ProcStat, f func(*nux.CpuUsage) uint64) float64 {
+	dt := curr.Cpu.Total - prev.Cpu.Total
+	if dt == 0 {
+		return 0.0
+	}
+	return float64(f(curr.Cpu)-f(prev.Cpu)) * 100.00 / float64(dt)
+}
+
+// CpuMetrics reports the averages over the whole interval since the last
+// call, instead of the last second only.
 func CpuMetrics() []*model.MetricValue {
-	if !CpuPrepared() {
+	psLock.Lock()
+	curr, prev := procStatHistory[0], procStatHistory[1]
+	if prev == nil {
+		psLock.Unlock()
 		return []*model.MetricValue{}
 	}
+	if procStatReported != nil && procStatReported.Cpu.Total < curr.Cpu.Total {
+		prev = procStatReported
+	}
+	procStatReported = curr
+	psLock.Unlock()
 
-	cpuIdleVal := CpuIdle()
+	cpuIdleVal := cpuPercent(curr, prev, func(u *nux.CpuUsage) uint64 { return u.Idle })
 	idle := GaugeValue("cpu.idle", cpuIdleVal)
 	busy := GaugeValue("cpu.busy", 100.0-cpuIdleVal)
-	user := GaugeValue("cpu.user", CpuUser())
-	nice := GaugeValue("cpu.nice", CpuNice())
-	system := GaugeValue("cpu.system", CpuSystem())
-	iowait := GaugeValue("cpu.iowait", CpuIowait())
-	irq := GaugeValue("cpu.irq", CpuIrq())
-	softirq := GaugeValue("cpu.softirq", CpuSoftIrq())
-	steal := GaugeValue("cpu.steal", CpuSteal())
-	guest := GaugeValue("cpu.guest", CpuGuest())
-	switches := CounterValue("cpu.switches", CurrentCpuSwitches())
+	user := GaugeValue("cpu.user", cpuPercent(curr, prev, func(u *nux.CpuUsage) uint64 { return u.User }))
+	nice := GaugeValue("cpu.nice", cpuPercent(curr, prev, func(u *nux.CpuUsage) uint64 { return u.Nice }))
+	system := GaugeValue("cpu.system", cpuPercent(curr, prev, func(u *nux.CpuUsage) uint64 { return u.System }))
+	iowait := GaugeValue("cpu.iowait", cpuPercent(curr, prev, func(u *nux.CpuUsage) uint64 { return u.Iowait }))
+	irq := GaugeValue("cpu.irq", cpuPercent(curr, prev, func(u *nux.CpuUsage) uint64 { return u.Irq }))
+	softirq := GaugeValue("cpu.softirq", cpuPercent(curr, prev, func(u *nux.CpuUsage) uint64 { return u.SoftIrq }))
+	steal := GaugeValue("cpu.steal", cpuPercent(curr, prev, func(u *nux.CpuUsage) uint64 { return u.Steal }))
+	guest := GaugeValue("cpu.guest", cpuPercent(curr, prev, func(u *nux.CpuUsage) uint64 { return u.Guest }))
+	switches := CounterValue("cpu.switches", curr.Ctxt)
 	return []*model.MetricValue{idle, busy, user, nice, system, iowait, irq, softirq, steal, guest, switches}
 }
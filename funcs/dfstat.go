@@ -2,13 +2,175 @@ package funcs
 
 import (
 	"fmt"
+	"github.com/open-falcon/agent/g"
 	"github.com/open-falcon/common/model"
 	"github.com/toolkits/nux"
 	"log"
+	"sync"
+	"syscall"
+	"time"
 )
 
-func DeviceMetrics() (L []*model.MetricValue) {
+const (
+	DefaultFsExclude    = `^(overlay|aufs|squashfs|nsfs|tracefs|fuse\.lxcfs)$`
+	DefaultMountExclude = `^/(var/lib/(docker|containers|kubelet)|(var/)?run/(docker|containerd|netns|k3s))/`
+
+	defaultMountTimeout = 5000
+
+	// ST_RDONLY of statfs(2)
+	stRdonly = 0x1
+)
+
+type MountStat struct {
+	FsSpec    string
+	FsFile    string
+	FsVfstype string
+	Usage     *nux.DeviceUsage
+	ReadOnly  bool
+	Hung      bool
+}
+
+type mountProbe struct {
+	usage    *nux.DeviceUsage
+	dev      uint64
+	readOnly bool
+	err      error
+}
+
+var (
+	fsFilter    = &NameFilter{DefaultExclude: DefaultFsExclude}
+	mountFilter = &NameFilter{DefaultExclude: DefaultMountExclude}
+
+	// mount points whose last probe has not returned yet, e.g. stale nfs
+	pendingProbes     = make(map[string]bool)
+	pendingProbesLock = new(sync.Mutex)
+)
+
+func shouldHandleMount(mount [3]string) bool {
+	c := g.Config().Collector
+	if c == nil {
+		return fsFilter.Match(mount[2], "", nil) && mountFilter.Match(mount[1], "", nil)
+	}
+	return fsFilter.Match(mount[2], c.FsInclude, c.FsExclude) && mountFilter.Match(mount[1], c.MountInclude, c.MountExclude)
+}
+
+// MountStats probes every selected mount point with a timeout, so that a
+// hung mount is reported instead of blocking the caller. Mounts of the same
+// underlying device, e.g. bind mounts, are reported only once.
+func MountStats() ([]*MountStat, error) {
 	mountPoints, err := nux.ListMountPoint()
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := defaultMountTimeout
+	if c := g.Config().Collector; c != nil && c.MountTimeout > 0 {
+		timeout = c.MountTimeout
+	}
+	deadline := time.After(time.Duration(timeout) * time.Millisecond)
+
+	var mounts [][3]string
+	var probes []chan *mountProbe
+	for _, mount := range mountPoints {
+		if !shouldHandleMount(mount) {
+			continue
+		}
+		mounts = append(mounts, mount)
+		probes = append(probes, probeMount(mount))
+	}
+
+	ret := make([]*MountStat, 0, len(mounts))
+	devs := make(map[uint64]bool)
+	timedOut := false
+
+	for idx, mount := range mounts {
+		ms := &MountStat{FsSpec: mount[0], FsFile: mount[1], FsVfstype: mount[2]}
+
+		var p *mountProbe
+		if ch := probes[idx]; ch != nil {
+			if !timedOut {
+				select {
+				case p = <-ch:
+				case <-deadline:
+					timedOut = true
+				}
+			}
+			if p == nil {
+				select {
+				case p = <-ch:
+				default:
+				}
+			}
+		}
+
+		if p == nil {
+			ms.Hung = true
+			ret = append(ret, ms)
+			continue
+		}
+
+		if p.err != nil {
+			log.Println("probe mount point", mount[1], "fail:", p.err)
+			continue
+		}
+
+		if devs[p.dev] {
+			continue
+		}
+		devs[p.dev] = true
+
+		ms.Usage = p.usage
+		ms.ReadOnly = p.readOnly
+		ret = append(ret, ms)
+	}
+
+	return ret, nil
+}
+
+// probeMount returns nil if the previous probe of the mount is still hung
+func probeMount(mount [3]string) chan *mountProbe {
+	pendingProbesLock.Lock()
+	defer pendingProbesLock.Unlock()
+
+	if pendingProbes[mount[1]] {
+		return nil
+	}
+	pendingProbes[mount[1]] = true
+
+	ch := make(chan *mountProbe, 1)
+	go func() {
+		p := doProbeMount(mount)
+
+		pendingProbesLock.Lock()
+		delete(pendingProbes, mount[1])
+		pendingProbesLock.Unlock()
+
+		ch <- p
+	}()
+	return ch
+}
+
+func doProbeMount(mount [3]string) *mountProbe {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(mount[1], &fs); err != nil {
+		return &mountProbe{err: err}
+	}
+
+	var st syscall.Stat_t
+	if err := syscall.Stat(mount[1], &st); err != nil {
+		return &mountProbe{err: err}
+	}
+
+	du, err := nux.BuildDeviceUsage(mount[0], mount[1], mount[2])
+	if err != nil {
+		return &mountProbe{err: err}
+	}
+
+	return &mountProbe{usage: du, dev: uint64(st.Dev), readOnly: fs.Flags&stRdonly != 0}
+}
+
+func DeviceMetrics() (L []*model.MetricValue) {
+	mountStats, err := MountStats()
 
 	if err != nil {
 		log.Println(err)
@@ -18,18 +180,24 @@ func DeviceMetrics() (L []*model.MetricValue) {
 	var diskTotal uint64 = 0
 	var diskUsed uint64 = 0
 
-	for idx := range mountPoints {
-		var du *nux.DeviceUsage
-		du, err = nux.BuildDeviceUsage(mountPoints[idx][0], mountPoints[idx][1], mountPoints[idx][2])
-		if err != nil {
-			log.Println(err)
+	for _, ms := range mountStats {
+		tags := fmt.Sprintf("mount=%s,fstype=%s", ms.FsFile, ms.FsVfstype)
+		if ms.Hung {
+			L = append(L, GaugeValue("df.mount.hung", 1, tags))
 			continue
 		}
 
+		L = append(L, GaugeValue("df.mount.hung", 0, tags))
+		if ms.ReadOnly {
+			L = append(L, GaugeValue("df.mount.readonly", 1, tags))
+		} else {
+			L = append(L, GaugeValue("df.mount.readonly", 0, tags))
+		}
+
+		du := ms.Usage
 		diskTotal += du.BlocksAll
 		diskUsed += du.BlocksUsed
 
-		tags := fmt.Sprintf("mount=%s,fstype=%s", du.FsFile, du.FsVfstype)
 		L = append(L, GaugeValue("df.bytes.total", du.BlocksAll, tags))
 		L = append(L, GaugeValue("df.bytes.used", du.BlocksUsed, tags))
 		L = append(L, GaugeValue("df.bytes.free", du.BlocksFree, tags))
@@ -43,6 +211,8 @@ func DeviceMetrics() (L []*model.MetricValue) {
 
 	}
 
+	L = append(L, DfTrendMetrics(mountStats)...)
+
 	if len(L) > 0 && diskTotal > 0 {
 		L = append(L, GaugeValue("df.statistics.total", float64(diskTotal)))
 		L = append(L, GaugeValue("df.statistics.used", float64(diskUsed)))
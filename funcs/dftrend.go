@@ -0,0 +1,187 @@
+package funcs
+
+import (
+	"encoding/json"
+	"github.com/open-falcon/agent/g"
+	"github.com/open-falcon/common/model"
+	"github.com/toolkits/file"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDfTrendFile  = "var/dftrend.json"
+	defaultDfTrendHours = 6
+
+	// fewer samples than this give no prediction
+	dfTrendMinSamples = 5
+	// hours_until_full of a mount that is not filling up
+	maxHoursUntilFull = 24 * 365
+)
+
+type dfSample struct {
+	TS     int64  `json:"ts"`
+	Bytes  uint64 `json:"bytes"`
+	Inodes uint64 `json:"inodes"`
+}
+
+var (
+	// tags of mount => samples in the window, oldest first
+	dfTrend       map[string][]dfSample
+	dfTrendLock   = new(sync.Mutex)
+	dfTrendLoaded sync.Once
+)
+
+func dfTrendConfig() (string, int64) {
+	path, hours := defaultDfTrendFile, defaultDfTrendHours
+	if c := g.Config().Collector; c != nil {
+		if c.DfTrendFile != "" {
+			path = c.DfTrendFile
+		}
+		if c.DfTrendHours > 0 {
+			hours = c.DfTrendHours
+		}
+	}
+
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(g.Root, path)
+	}
+	return path, int64(hours) * 3600
+}
+
+func loadDfTrend() {
+	dfTrend = make(map[string][]dfSample)
+
+	path, _ := dfTrendConfig()
+	if !file.IsExist(path) {
+		return
+	}
+
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Println("read", path, "fail:", err)
+		return
+	}
+
+	if err = json.Unmarshal(bs, &dfTrend); err != nil {
+		log.Println("parse", path, "fail:", err)
+		dfTrend = make(map[string][]dfSample)
+	}
+}
+
+func saveDfTrend() {
+	path, _ := dfTrendConfig()
+
+	bs, err := json.Marshal(dfTrend)
+	if err != nil {
+		log.Println("json.Marshal df trend fail:", err)
+		return
+	}
+
+	file.InsureDir(filepath.Dir(path))
+	tmp := path + ".tmp"
+	if err = ioutil.WriteFile(tmp, bs, 0644); err != nil {
+		log.Println("write", tmp, "fail:", err)
+		return
+	}
+
+	if err = os.Rename(tmp, path); err != nil {
+		log.Println("rename", tmp, "fail:", err)
+	}
+}
+
+// DfTrendMetrics adds the samples of this round to the window of every mount,
+// fits a line through each window, and reports how many hours are left until
+// bytes and inodes run out at the current rate.
+func DfTrendMetrics(mountStats []*MountStat) (L []*model.MetricValue) {
+	dfTrendLoaded.Do(loadDfTrend)
+
+	now := time.Now().Unix()
+	_, window := dfTrendConfig()
+
+	dfTrendLock.Lock()
+	defer dfTrendLock.Unlock()
+
+	seen := make(map[string]bool)
+	for _, ms := range mountStats {
+		du := ms.Usage
+		if du == nil {
+			continue
+		}
+
+		tags := "mount=" + ms.FsFile + ",fstype=" + ms.FsVfstype
+		seen[tags] = true
+
+		samples := append(dfTrend[tags], dfSample{TS: now, Bytes: du.BlocksUsed, Inodes: du.InodesUsed})
+		samples = trimDfSamples(samples, now-window)
+		dfTrend[tags] = samples
+
+		if len(samples) < dfTrendMinSamples {
+			continue
+		}
+
+		bytesSlope := dfSlope(samples, func(s dfSample) uint64 { return s.Bytes })
+		L = append(L, GaugeValue("df.bytes.hours_until_full", hoursUntilFull(du.BlocksFree, bytesSlope), tags))
+
+		if du.InodesAll > 0 {
+			inodesSlope := dfSlope(samples, func(s dfSample) uint64 { return s.Inodes })
+			L = append(L, GaugeValue("df.inodes.hours_until_full", hoursUntilFull(du.InodesFree, inodesSlope), tags))
+		}
+	}
+
+	// forget mounts which are gone for a whole window
+	for tags, samples := range dfTrend {
+		if !seen[tags] && (len(samples) == 0 || samples[len(samples)-1].TS < now-window) {
+			delete(dfTrend, tags)
+		}
+	}
+
+	saveDfTrend()
+	return
+}
+
+func trimDfSamples(samples []dfSample, since int64) []dfSample {
+	i := 0
+	for i < len(samples) && samples[i].TS < since {
+		i++
+	}
+	return samples[i:]
+}
+
+// dfSlope is the least squares slope of f over time, in units per second
+func dfSlope(samples []dfSample, f func(dfSample) uint64) float64 {
+	n := float64(len(samples))
+	t0, v0 := samples[0].TS, f(samples[0])
+
+	var sumT, sumV, sumTT, sumTV float64
+	for _, s := range samples {
+		t := float64(s.TS - t0)
+		v := float64(f(s)) - float64(v0)
+		sumT += t
+		sumV += v
+		sumTT += t * t
+		sumTV += t * v
+	}
+
+	d := n*sumTT - sumT*sumT
+	if d == 0 {
+		return 0
+	}
+	return (n*sumTV - sumT*sumV) / d
+}
+
+func hoursUntilFull(free uint64, slope float64) float64 {
+	if slope <= 0 {
+		return maxHoursUntilFull
+	}
+
+	hours := float64(free) / slope / 3600.0
+	if hours > maxHoursUntilFull {
+		return maxHoursUntilFull
+	}
+	return hours
+}
@@ -2,9 +2,12 @@ package funcs
 
 import (
 	"fmt"
+	"github.com/open-falcon/agent/g"
 	"github.com/open-falcon/common/model"
 	"github.com/toolkits/nux"
+	"io/ioutil"
 	"log"
+	"path/filepath"
 	"strings"
 	"sync"
 )
@@ -12,6 +15,11 @@ import (
 var (
 	diskStatsMap = make(map[string][2]*nux.DiskStats)
 	dsLock       = new(sync.RWMutex)
+
+	// sample of every device taken by the last IOStatsMetrics call, and the
+	// peak %util of the 1s samples seen since then
+	diskStatsReported = make(map[string]*nux.DiskStats)
+	diskUtilPeak      = make(map[string]float64)
 )
 
 func UpdateDiskStats() error {
@@ -24,7 +32,16 @@ func UpdateDiskStats() error {
 	defer dsLock.Unlock()
 	for i := 0; i < len(dsList); i++ {
 		device := dsList[i].Device
-		diskStatsMap[device] = [2]*nux.DiskStats{dsList[i], diskStatsMap[device][0]}
+		arr := [2]*nux.DiskStats{dsList[i], diskStatsMap[device][0]}
+		diskStatsMap[device] = arr
+
+		if arr[1] == nil {
+			continue
+		}
+
+		if util := IOUtil(arr); util > diskUtilPeak[device] {
+			diskUtilPeak[device] = util
+		}
 	}
 	return nil
 }
@@ -73,6 +90,20 @@ func TS(arr [2]*nux.DiskStats) uint64 {
 	return uint64(arr[0].TS.Sub(arr[1].TS).Nanoseconds() / 1000000)
 }
 
+// IOUtil is %util between two samples
+func IOUtil(arr [2]*nux.DiskStats) float64 {
+	duration := TS(arr)
+	if duration == 0 {
+		return 0.0
+	}
+
+	util := float64(IOMsecTotal(arr)) * 100.0 / float64(duration)
+	if util > 100.0 {
+		util = 100.0
+	}
+	return util
+}
+
 func IODelta(device string, f func([2]*nux.DiskStats) uint64) uint64 {
 	val, ok := diskStatsMap[device]
 	if !ok {
@@ -98,7 +129,7 @@ func DiskIOMetrics() (L []*model.MetricValue) {
 			continue
 		}
 
-		device := "device=" + ds.Device
+		device := DeviceTags(ds.Device)
 
 		L = append(L, CounterValue("disk.io.read_requests", ds.ReadRequests, device))
 		L = append(L, CounterValue("disk.io.read_merged", ds.ReadMerged, device))
@@ -115,23 +146,45 @@ func DiskIOMetrics() (L []*model.MetricValue) {
 	return
 }
 
+// IOStatsMetrics reports the averages over the whole interval since the last
+// call, so that a long step does not only show the last second.
 func IOStatsMetrics() (L []*model.MetricValue) {
-	dsLock.RLock()
-	defer dsLock.RUnlock()
+	dsLock.Lock()
+	defer dsLock.Unlock()
 
-	for device, _ := range diskStatsMap {
+	for device, val := range diskStatsMap {
 		if !ShouldHandleDevice(device) {
 			continue
 		}
 
-		tags := "device=" + device
-		rio := IODelta(device, IOReadRequests)
-		wio := IODelta(device, IOWriteRequests)
-		delta_rsec := IODelta(device, IOReadSectors)
-		delta_wsec := IODelta(device, IOWriteSectors)
-		ruse := IODelta(device, IOMsecRead)
-		wuse := IODelta(device, IOMsecWrite)
-		use := IODelta(device, IOMsecTotal)
+		if val[1] == nil {
+			continue
+		}
+
+		// first call: there is no previous report, use the last second
+		arr := val
+		if prev, ok := diskStatsReported[device]; ok && prev.TS.Before(val[0].TS) {
+			arr = [2]*nux.DiskStats{val[0], prev}
+		}
+
+		peak := diskUtilPeak[device]
+		diskStatsReported[device] = val[0]
+		delete(diskUtilPeak, device)
+
+		duration := TS(arr)
+		if duration == 0 {
+			continue
+		}
+		seconds := float64(duration) / 1000.0
+
+		tags := DeviceTags(device)
+		rio := IOReadRequests(arr)
+		wio := IOWriteRequests(arr)
+		delta_rsec := IOReadSectors(arr)
+		delta_wsec := IOWriteSectors(arr)
+		ruse := IOMsecRead(arr)
+		wuse := IOMsecWrite(arr)
+		use := IOMsecTotal(arr)
 		n_io := rio + wio
 		avgrq_sz := 0.0
 		await := 0.0
@@ -142,19 +195,21 @@ func IOStatsMetrics() (L []*model.MetricValue) {
 			svctm = float64(use) / float64(n_io)
 		}
 
-		duration := IODelta(device, TS)
+		util := IOUtil(arr)
+		if peak < util {
+			peak = util
+		}
 
-		L = append(L, GaugeValue("disk.io.read_bytes", float64(delta_rsec)*512.0, tags))
-		L = append(L, GaugeValue("disk.io.write_bytes", float64(delta_wsec)*512.0, tags))
+		L = append(L, GaugeValue("disk.io.read_bytes", float64(delta_rsec)*512.0/seconds, tags))
+		L = append(L, GaugeValue("disk.io.write_bytes", float64(delta_wsec)*512.0/seconds, tags))
 		L = append(L, GaugeValue("disk.io.avgrq_sz", avgrq_sz, tags))
-		L = append(L, GaugeValue("disk.io.avgqu-sz", float64(IODelta(device, IOMsecWeightedTotal))/1000.0, tags))
+		L = append(L, GaugeValue("disk.io.avgqu-sz", float64(IOMsecWeightedTotal(arr))/float64(duration), tags))
 		L = append(L, GaugeValue("disk.io.await", await, tags))
 		L = append(L, GaugeValue("disk.io.svctm", svctm, tags))
-		tmp := float64(use) * 100.0 / float64(duration)
-		if tmp > 100.0 {
-			tmp = 100.0
+		L = append(L, GaugeValue("disk.io.util", util, tags))
+		if c := g.Config().Collector; c != nil && c.DiskUtilPeak {
+			L = append(L, GaugeValue("disk.io.util.max", peak, tags))
 		}
-		L = append(L, GaugeValue("disk.io.util", tmp, tags))
 	}
 
 	return
@@ -208,8 +263,62 @@ func IOStatsForPage() (L [][]string) {
 	return
 }
 
+// DefaultDiskInclude matches whole block devices: sd*, vd*, hd*, nvme
+// namespaces, mmc cards, md RAID and device-mapper, and xvd* with partitions
+// as before. Partitions of the others, e.g. sda10, need collector.diskInclude.
+const DefaultDiskInclude = `^((s|v|h)d[a-z]+|xvd[a-z]+[0-9]*|nvme[0-9]+n[0-9]+|mmcblk[0-9]+|md[0-9]+|dm-[0-9]+)$`
+
+var diskFilter = &NameFilter{DefaultInclude: DefaultDiskInclude}
+
 func ShouldHandleDevice(device string) bool {
-	normal := len(device) == 3 && (strings.HasPrefix(device, "sd") || strings.HasPrefix(device, "vd"))
-	aws := len(device) >= 4 && strings.HasPrefix(device, "xvd")
-	return normal || aws
+	c := g.Config().Collector
+	if c == nil {
+		return diskFilter.Match(device, "", nil)
+	}
+	return diskFilter.Match(device, c.DiskInclude, c.DiskExclude)
+}
+
+// DeviceTags returns the tags of a block device: device=xx, plus dm=name for
+// device-mapper devices and id=xx (wwid or dm uuid) if diskStableId is on.
+func DeviceTags(device string) string {
+	tags := "device=" + device
+
+	sysDir := filepath.Join("/sys/block", device)
+	if strings.HasPrefix(device, "dm-") {
+		if name := readSysAttr(filepath.Join(sysDir, "dm", "name")); name != "" {
+			tags += ",dm=" + name
+		}
+	}
+
+	if c := g.Config().Collector; c != nil && c.DiskStableId {
+		if id := stableDeviceId(sysDir); id != "" {
+			tags += ",id=" + id
+		}
+	}
+
+	return tags
+}
+
+func stableDeviceId(sysDir string) string {
+	for _, attr := range []string{"dm/uuid", "md/uuid", "wwid", "device/wwid", "device/serial"} {
+		if id := readSysAttr(filepath.Join(sysDir, attr)); id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+// readSysAttr reads a sysfs attribute, making it safe to use as a tag value
+func readSysAttr(path string) string {
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+
+	return strings.Map(func(r rune) rune {
+		if r == ',' || r == '=' {
+			return '_'
+		}
+		return r
+	}, strings.Join(strings.Fields(string(bs)), "_"))
 }
@@ -1,36 +1,257 @@
 package funcs
 
 import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"syscall"
+	"time"
+
 	"github.com/open-falcon/agent/g"
 	"github.com/open-falcon/common/model"
-	"github.com/toolkits/sys"
-	"log"
-	"strconv"
-	"strings"
 )
 
+const (
+	// at most so many paths are walked at the same time
+	duWalkers = 2
+	// defaults of collector.duTimeout and collector.duRefresh, in seconds
+	defaultDuTimeout = 60
+	defaultDuRefresh = 300
+)
+
+// DuResult is what a walk of a du.bs path found, like du -bs, the size is
+// the apparent size of the files and directories, hard links counted once
+type DuResult struct {
+	Size   uint64
+	Files  uint64
+	Oldest time.Time
+	Newest time.Time
+	// some entries could not be read
+	Partial bool
+	// the walk was stopped by collector.duTimeout
+	TimedOut bool
+	// Size, Files, Oldest and Newest are of a walk which was not stopped,
+	// the last one if this one timed out
+	Complete bool
+	At       time.Time
+	Took     time.Duration
+}
+
+// path => the last result, and whether it is being walked
+var (
+	duResults = make(map[string]*DuResult)
+	duWalking = make(map[string]bool)
+	duLock    = new(sync.Mutex)
+	duSem     = make(chan bool, duWalkers)
+)
+
+// DuMetrics reports the cached results, and walks the paths whose result is
+// older than collector.duRefresh in the background, so that a huge
+// directory never blocks collecting
 func DuMetrics() (L []*model.MetricValue) {
 	paths := g.DuPaths()
+	timeout, refresh, rate := duConfig()
+
+	wanted := make(map[string]bool, len(paths))
+	duLock.Lock()
 	for _, path := range paths {
-		out, err := sys.CmdOutNoLn("du", "-bs", path)
-		if err != nil {
-			log.Println("du -bs", path, "fail", err)
-			continue
+		wanted[path] = true
+
+		r, ok := duResults[path]
+		if (!ok || time.Since(r.At) >= refresh) && !duWalking[path] {
+			duWalking[path] = true
+			go walkDu(path, timeout, rate)
 		}
+	}
 
-		arr := strings.Fields(out)
-		if len(arr) == 1 {
-			continue
+	for path := range duResults {
+		if !wanted[path] {
+			delete(duResults, path)
 		}
+	}
 
-		size, err := strconv.ParseUint(arr[0], 10, 64)
-		if err != nil {
-			log.Println("cannot parse du -bs", path, "output")
-			continue
+	results := make(map[string]*DuResult, len(paths))
+	for path := range wanted {
+		if r, ok := duResults[path]; ok {
+			results[path] = r
 		}
+	}
+	duLock.Unlock()
 
-		L = append(L, GaugeValue(g.DU_BS, size, "path="+path))
+	now := time.Now()
+	for path, r := range results {
+		tags := "path=" + path
+		if r.Complete {
+			L = append(L, GaugeValue(g.DU_BS, r.Size, tags))
+			L = append(L, GaugeValue("du.files", r.Files, tags))
+			if r.Files > 0 {
+				L = append(L, GaugeValue("du.mtime.oldest.age", now.Sub(r.Oldest).Seconds(), tags))
+				L = append(L, GaugeValue("du.mtime.newest.age", now.Sub(r.Newest).Seconds(), tags))
+			}
+		}
+		L = append(L, GaugeValue("du.partial", boolToInt(r.Partial), tags))
+		L = append(L, GaugeValue("du.timeout", boolToInt(r.TimedOut), tags))
+		L = append(L, GaugeValue("du.walk.time", r.Took.Seconds(), tags))
 	}
 
 	return
 }
+
+func duConfig() (timeout, refresh time.Duration, rate int) {
+	timeout = defaultDuTimeout * time.Second
+	refresh = defaultDuRefresh * time.Second
+
+	if c := g.Config().Collector; c != nil {
+		if c.DuTimeout > 0 {
+			timeout = time.Duration(c.DuTimeout) * time.Second
+		}
+		if c.DuRefresh > 0 {
+			refresh = time.Duration(c.DuRefresh) * time.Second
+		}
+		rate = c.DuRate
+	}
+	return
+}
+
+func walkDu(path string, timeout time.Duration, rate int) {
+	duSem <- true
+
+	// the thread exits with the goroutine, so its io priority is not
+	// inherited by other goroutines
+	runtime.LockOSThread()
+	setIdleIoPriority()
+
+	r := WalkDu(path, timeout, rate)
+	<-duSem
+
+	duLock.Lock()
+	defer duLock.Unlock()
+	delete(duWalking, path)
+	if r.Partial || r.TimedOut {
+		log.Println("du", path, "partial:", r.Partial, "timeout:", r.TimedOut)
+	}
+	// the size of a stopped walk is too small, keep the last complete one
+	if last, ok := duResults[path]; ok && r.TimedOut && last.Complete {
+		r.Size, r.Files, r.Oldest, r.Newest = last.Size, last.Files, last.Oldest, last.Newest
+		r.Complete = true
+	}
+	duResults[path] = r
+}
+
+// ioprio_set(IOPRIO_WHO_PROCESS, 0, IOPRIO_CLASS_IDLE), the calling thread
+// only does io when the disk is idle otherwise
+func setIdleIoPriority() {
+	const ioprioClassIdle = 3
+	const ioprioClassShift = 13
+	const ioprioWhoProcess = 1
+	_, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, 0, ioprioClassIdle<<ioprioClassShift)
+	if errno != 0 {
+		log.Println("ioprio_set fail", errno)
+	}
+}
+
+type duWalker struct {
+	r        *DuResult
+	deadline time.Time
+	start    time.Time
+	rate     int
+	entries  int
+	inodes   map[[2]uint64]bool
+}
+
+// WalkDu walks a path without following symlinks, stopping after timeout,
+// and looking at no more than rate entries a second if rate > 0
+func WalkDu(path string, timeout time.Duration, rate int) *DuResult {
+	w := &duWalker{
+		r:        &DuResult{},
+		start:    time.Now(),
+		deadline: time.Now().Add(timeout),
+		rate:     rate,
+		inodes:   make(map[[2]uint64]bool),
+	}
+
+	if fi, err := os.Lstat(path); err != nil {
+		log.Println("du", path, "fail", err)
+		w.r.Partial = true
+	} else {
+		w.add(path, fi)
+	}
+
+	w.r.Complete = !w.r.TimedOut
+	w.r.At = time.Now()
+	w.r.Took = w.r.At.Sub(w.start)
+	return w.r
+}
+
+// add counts the entry at path, and walks into it if it is a directory
+func (this *duWalker) add(path string, fi os.FileInfo) bool {
+	if this.r.TimedOut {
+		return false
+	}
+
+	this.entries++
+	if this.entries%100 == 0 {
+		now := time.Now()
+		if now.After(this.deadline) {
+			this.r.TimedOut = true
+			return false
+		}
+		if this.rate > 0 {
+			expected := time.Duration(this.entries) * time.Second / time.Duration(this.rate)
+			if elapsed := now.Sub(this.start); elapsed < expected {
+				time.Sleep(expected - elapsed)
+			}
+		}
+	}
+
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok && st.Nlink > 1 && !fi.IsDir() {
+		key := [2]uint64{uint64(st.Dev), uint64(st.Ino)}
+		if this.inodes[key] {
+			return true
+		}
+		this.inodes[key] = true
+	}
+
+	this.r.Size += uint64(fi.Size())
+	if !fi.IsDir() {
+		this.r.Files++
+		mtime := fi.ModTime()
+		if this.r.Files == 1 || mtime.Before(this.r.Oldest) {
+			this.r.Oldest = mtime
+		}
+		if this.r.Files == 1 || mtime.After(this.r.Newest) {
+			this.r.Newest = mtime
+		}
+		return true
+	}
+
+	return this.walk(path)
+}
+
+func (this *duWalker) walk(dir string) bool {
+	f, err := os.Open(dir)
+	if err != nil {
+		this.r.Partial = true
+		return true
+	}
+	defer f.Close()
+
+	for {
+		fis, err := f.Readdir(256)
+		for _, fi := range fis {
+			if !this.add(filepath.Join(dir, fi.Name()), fi) {
+				return false
+			}
+		}
+		if err == io.EOF {
+			return true
+		}
+		if err != nil {
+			this.r.Partial = true
+			return true
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package funcs
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/open-falcon/agent/g"
+	"github.com/open-falcon/common/model"
+)
+
+// FileStat is what a file.exists found, of the files matching the path,
+// and of the files directly in the directories matching it
+type FileStat struct {
+	Exists bool
+	Size   uint64
+	Count  int
+	Newest time.Time
+	Lines  uint64
+	// lines added since the last round, -1 if there was no last round
+	Growth int64
+}
+
+type lineCount struct {
+	ino   uint64
+	size  int64
+	lines uint64
+}
+
+// tags of file.exists => file => lines counted in the last round, so that
+// only what is appended since is read
+var (
+	fileLines     = make(map[string]map[string]*lineCount)
+	fileLinesLock = new(sync.Mutex)
+)
+
+// AllFileChecks are the file.exists of collector.files and of hbs
+func AllFileChecks() map[string]*g.FileCheck {
+	local := g.LocalFiles()
+	remote := g.ReportFiles()
+
+	ret := make(map[string]*g.FileCheck, len(local)+len(remote))
+	for tags, c := range local {
+		ret[tags] = c
+	}
+	for tags, c := range remote {
+		ret[tags] = c
+	}
+	return ret
+}
+
+func FileMetrics() (L []*model.MetricValue) {
+	checks := AllFileChecks()
+
+	fileLinesLock.Lock()
+	for tags := range fileLines {
+		if c, ok := checks[tags]; !ok || !c.Lines {
+			delete(fileLines, tags)
+		}
+	}
+	fileLinesLock.Unlock()
+
+	now := time.Now()
+	for tags, c := range checks {
+		st := StatFiles(c)
+		L = append(L, GaugeValue(g.FILE_EXISTS, boolToInt(st.Exists), tags))
+		if !st.Exists {
+			continue
+		}
+
+		L = append(L, GaugeValue("file.size", st.Size, tags))
+		L = append(L, GaugeValue("file.count", st.Count, tags))
+		L = append(L, GaugeValue("file.mtime.age", now.Sub(st.Newest).Seconds(), tags))
+		if c.Lines {
+			L = append(L, GaugeValue("file.lines", st.Lines, tags))
+			if st.Growth >= 0 {
+				L = append(L, GaugeValue("file.lines.growth", st.Growth, tags))
+			}
+		}
+	}
+	return
+}
+
+// StatFiles globs the path of a check, directories are not walked into
+// further than their direct entries
+func StatFiles(c *g.FileCheck) *FileStat {
+	st := &FileStat{Growth: -1}
+
+	matches, err := filepath.Glob(c.Path)
+	if err != nil {
+		log.Println("glob", c.Path, "fail", err)
+		return st
+	}
+
+	files := make(map[string]os.FileInfo)
+	for _, path := range matches {
+		fi, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		st.Exists = true
+		if fi.ModTime().After(st.Newest) {
+			st.Newest = fi.ModTime()
+		}
+
+		if !fi.IsDir() {
+			files[path] = fi
+			continue
+		}
+
+		fis, err := ioutil.ReadDir(path)
+		if err != nil {
+			log.Println("read dir", path, "fail", err)
+			continue
+		}
+		for _, entry := range fis {
+			if !entry.IsDir() {
+				files[filepath.Join(path, entry.Name())] = entry
+			}
+		}
+	}
+
+	for _, fi := range files {
+		st.Count++
+		st.Size += uint64(fi.Size())
+		if fi.ModTime().After(st.Newest) {
+			st.Newest = fi.ModTime()
+		}
+	}
+
+	if c.Lines {
+		st.Lines, st.Growth = countFileLines(c.Tags, files)
+	}
+	return st
+}
+
+// countFileLines counts the lines of the regular files, reading only what
+// is appended to a file since the last round. A file which is replaced or
+// truncated is read again from the start, all of its lines are growth.
+func countFileLines(tags string, files map[string]os.FileInfo) (uint64, int64) {
+	fileLinesLock.Lock()
+	defer fileLinesLock.Unlock()
+
+	last, seen := fileLines[tags]
+	curr := make(map[string]*lineCount, len(files))
+
+	var total uint64
+	var growth int64
+	for path, fi := range files {
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+
+		var ino uint64
+		if sys, ok := fi.Sys().(*syscall.Stat_t); ok {
+			ino = uint64(sys.Ino)
+		}
+
+		lc := &lineCount{ino: ino}
+		prev, ok := last[path]
+		if ok && prev.ino == ino && prev.size <= fi.Size() {
+			lc.size, lc.lines = prev.size, prev.lines
+		}
+
+		n, read, err := countLines(path, lc.size)
+		if err != nil {
+			log.Println("count lines of", path, "fail", err)
+			if ok {
+				curr[path] = prev
+				total += prev.lines
+			}
+			continue
+		}
+
+		lc.size += read
+		lc.lines += n
+		curr[path] = lc
+		total += lc.lines
+		// what was read is all new, either appended or a new file
+		growth += int64(n)
+	}
+
+	fileLines[tags] = curr
+	if !seen {
+		return total, -1
+	}
+	return total, growth
+}
+
+// countLines counts the newlines of a file from offset, returning the bytes
+// read
+func countLines(path string, offset int64) (uint64, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, 0, err
+	}
+
+	var lines uint64
+	var read int64
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := f.Read(buf)
+		lines += uint64(bytes.Count(buf[:n], []byte{'\n'}))
+		read += int64(n)
+		if err == io.EOF {
+			return lines, read, nil
+		}
+		if err != nil {
+			return lines, read, err
+		}
+	}
+}
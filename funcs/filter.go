@@ -0,0 +1,70 @@
+package funcs
+
+import (
+	"log"
+	"regexp"
+	"sync"
+)
+
+// NameFilter matches names against include/exclude regexps from the
+// configuration. The regexps are recompiled only when the configuration
+// changes. An empty include means the default one, or everything without a
+// default. An unset exclude means the default one, an empty one excludes
+// nothing. An invalid pattern falls back to the default.
+type NameFilter struct {
+	sync.Mutex
+	DefaultInclude string
+	DefaultExclude string
+
+	key     string
+	include *regexp.Regexp
+	exclude *regexp.Regexp
+}
+
+func (this *NameFilter) Match(name, include string, exclude *string) bool {
+	inc, exc := this.compile(include, exclude)
+	if inc != nil && !inc.MatchString(name) {
+		return false
+	}
+	return exc == nil || !exc.MatchString(name)
+}
+
+func (this *NameFilter) compile(include string, excludePtr *string) (*regexp.Regexp, *regexp.Regexp) {
+	if include == "" {
+		include = this.DefaultInclude
+	}
+	exclude := this.DefaultExclude
+	if excludePtr != nil {
+		exclude = *excludePtr
+	}
+
+	this.Lock()
+	defer this.Unlock()
+
+	key := include + "\x00" + exclude
+	if this.key == key {
+		return this.include, this.exclude
+	}
+
+	this.include = compilePattern(include, this.DefaultInclude)
+	this.exclude = compilePattern(exclude, this.DefaultExclude)
+	this.key = key
+	return this.include, this.exclude
+}
+
+func compilePattern(pattern, fallback string) *regexp.Regexp {
+	if pattern == "" {
+		return nil
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err == nil {
+		return re
+	}
+
+	log.Printf("invalid pattern %q, use %q instead. error: %v", pattern, fallback, err)
+	if fallback == "" || fallback == pattern {
+		return nil
+	}
+	return regexp.MustCompile(fallback)
+}
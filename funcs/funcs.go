@@ -20,14 +20,21 @@ func BuildMappers() {
 				AgentMetrics,
 				CpuMetrics,
 				NetMetrics,
+				LinkMetrics,
+				BondingMetrics,
 				KernelMetrics,
+				KernelStatMetrics,
+				ProcessMetrics,
 				LoadAvgMetrics,
 				MemMetrics,
 				DiskIOMetrics,
 				IOStatsMetrics,
 				NetstatMetrics,
-				ProcMetrics,
-				UdpMetrics,
+				ConntrackMetrics,
+				SoftnetMetrics,
+				InterruptMetrics,
+				SoftirqMetrics,
+				SnmpMetrics,
 			},
 			Interval: interval,
 		},
@@ -47,6 +54,7 @@ func BuildMappers() {
 		FuncsAndInterval{
 			Fs: []func() []*model.MetricValue{
 				DuMetrics,
+				FileMetrics,
 			},
 			Interval: interval,
 		},
@@ -56,5 +64,26 @@ func BuildMappers() {
 			},
 			Interval: interval,
 		},
+		FuncsAndInterval{
+			Fs: []func() []*model.MetricValue{
+				CertMetrics,
+				TcpConnectMetrics,
+				DnsResolveMetrics,
+			},
+			Interval: interval,
+		},
+		FuncsAndInterval{
+			Fs: []func() []*model.MetricValue{
+				PingMetrics,
+			},
+			Interval: interval,
+		},
+		FuncsAndInterval{
+			Fs: []func() []*model.MetricValue{
+				LogMetrics,
+				KmsgMetrics,
+			},
+			Interval: interval,
+		},
 	}
 }
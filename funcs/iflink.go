@@ -0,0 +1,91 @@
+package funcs
+
+import (
+	"github.com/open-falcon/common/model"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// operstate values of RFC 2863
+var operStates = map[string]int{
+	"up":             1,
+	"down":           2,
+	"testing":        3,
+	"unknown":        4,
+	"dormant":        5,
+	"notpresent":     6,
+	"lowerlayerdown": 7,
+}
+
+var duplexes = map[string]int{
+	"half": 1,
+	"full": 2,
+}
+
+func readSysNet(iface, attr string) (string, bool) {
+	bs, err := ioutil.ReadFile(filepath.Join("/sys/class/net", iface, attr))
+	if err != nil {
+		// e.g. carrier of an interface which is down
+		return "", false
+	}
+	return strings.TrimSpace(string(bs)), true
+}
+
+// LinkMetrics reports the link state of the selected interfaces from
+// /sys/class/net/$iface
+func LinkMetrics() (L []*model.MetricValue) {
+	fs, err := ioutil.ReadDir("/sys/class/net")
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	for _, f := range fs {
+		name := f.Name()
+		if !ShouldHandleIface(name) {
+			continue
+		}
+
+		iface := "iface=" + name
+
+		if state, ok := readSysNet(name, "operstate"); ok {
+			code, ok := operStates[state]
+			if !ok {
+				code = operStates["unknown"]
+			}
+			L = append(L, GaugeValue("net.if.operstate", code, iface))
+			if state == "up" {
+				L = append(L, GaugeValue("net.if.up", 1, iface))
+			} else {
+				L = append(L, GaugeValue("net.if.up", 0, iface))
+			}
+		}
+
+		if carrier, ok := readSysNet(name, "carrier"); ok {
+			if v, err := strconv.ParseInt(carrier, 10, 64); err == nil {
+				L = append(L, GaugeValue("net.if.carrier", v, iface))
+			}
+		}
+
+		if duplex, ok := readSysNet(name, "duplex"); ok {
+			L = append(L, GaugeValue("net.if.duplex", duplexes[duplex], iface))
+		}
+
+		if mtu, ok := readSysNet(name, "mtu"); ok {
+			if v, err := strconv.ParseInt(mtu, 10, 64); err == nil {
+				L = append(L, GaugeValue("net.if.mtu", v, iface))
+			}
+		}
+
+		if changes, ok := readSysNet(name, "carrier_changes"); ok {
+			if v, err := strconv.ParseUint(changes, 10, 64); err == nil {
+				L = append(L, CounterValue("net.if.carrier.changes", v, iface))
+			}
+		}
+	}
+
+	return
+}
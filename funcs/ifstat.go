@@ -5,10 +5,51 @@ import (
 	"github.com/open-falcon/common/model"
 	"github.com/toolkits/nux"
 	"log"
+	"strings"
 )
 
+var ifaceFilter = &NameFilter{}
+
+// ShouldHandleIface selects interfaces by collector.ifaceInclude, e.g.
+// ^(eth|ens|bond|team)[0-9.]+$, falling back to collector.ifacePrefix if it
+// is not set. collector.ifaceExclude applies in both cases.
+func ShouldHandleIface(iface string) bool {
+	c := g.Config().Collector
+	if c == nil {
+		return true
+	}
+
+	if c.IfaceInclude == "" && len(c.IfacePrefix) > 0 {
+		found := false
+		for _, prefix := range c.IfacePrefix {
+			if strings.HasPrefix(iface, prefix) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return ifaceFilter.Match(iface, c.IfaceInclude, c.IfaceExclude)
+}
+
 func NetMetrics() []*model.MetricValue {
-	return CoreNetMetrics(g.Config().Collector.IfacePrefix)
+	netIfs, err := nux.NetIfs([]string{})
+	if err != nil {
+		log.Println(err)
+		return []*model.MetricValue{}
+	}
+
+	selected := make([]*nux.NetIf, 0, len(netIfs))
+	for _, netIf := range netIfs {
+		if ShouldHandleIface(netIf.Iface) {
+			selected = append(selected, netIf)
+		}
+	}
+
+	return netIfMetrics(selected)
 }
 
 func CoreNetMetrics(ifacePrefix []string) []*model.MetricValue {
@@ -19,6 +60,10 @@ func CoreNetMetrics(ifacePrefix []string) []*model.MetricValue {
 		return []*model.MetricValue{}
 	}
 
+	return netIfMetrics(netIfs)
+}
+
+func netIfMetrics(netIfs []*nux.NetIf) []*model.MetricValue {
 	cnt := len(netIfs)
 	ret := make([]*model.MetricValue, cnt*23)
 
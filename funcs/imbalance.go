@@ -0,0 +1,46 @@
+package funcs
+
+import (
+	"sync"
+)
+
+// imbalanceTracker remembers the last per-cpu counters of every key and
+// computes how unevenly they grew across cpus since then.
+type imbalanceTracker struct {
+	sync.Mutex
+	last map[string][]uint64
+}
+
+func newImbalanceTracker() *imbalanceTracker {
+	return &imbalanceTracker{last: make(map[string][]uint64)}
+}
+
+// Update returns max/avg of the per-cpu deltas, 1 means perfectly balanced.
+// It returns false on the first call of a key, or if the cpus changed.
+func (this *imbalanceTracker) Update(key string, curr []uint64) (float64, bool) {
+	this.Lock()
+	defer this.Unlock()
+
+	prev, ok := this.last[key]
+	this.last[key] = curr
+	if !ok || len(prev) != len(curr) || len(curr) == 0 {
+		return 0, false
+	}
+
+	var sum, max uint64
+	for i := range curr {
+		if curr[i] < prev[i] {
+			return 0, false
+		}
+		delta := curr[i] - prev[i]
+		sum += delta
+		if delta > max {
+			max = delta
+		}
+	}
+
+	if sum == 0 {
+		return 1.0, true
+	}
+	return float64(max) * float64(len(curr)) / float64(sum), true
+}
@@ -0,0 +1,162 @@
+package funcs
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/open-falcon/common/model"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// IrqStat is a line of /proc/interrupts or /proc/softirqs
+type IrqStat struct {
+	Name   string
+	Device string
+	PerCpu []uint64
+}
+
+var (
+	irqImbalance     = newImbalanceTracker()
+	softirqImbalance = newImbalanceTracker()
+
+	// queue number of e.g. eth0-TxRx-3, nvme0q12, mlx5_comp7, virtio1-req.0,
+	// which follows a separator, so that e.g. i8042 is kept
+	irqQueueSuffix = regexp.MustCompile(`(?:[-_.][A-Za-z]*|[0-9]q)[0-9]+$`)
+)
+
+// ReadIrqStat parses /proc/interrupts or /proc/softirqs, whose first line
+// names the cpus, and every other line is "name: count per cpu [device]"
+func ReadIrqStat(path string) ([]*IrqStat, int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	if !scanner.Scan() {
+		return nil, 0, fmt.Errorf("%s is blank", path)
+	}
+	ncpu := len(strings.Fields(scanner.Text()))
+
+	var ret []*IrqStat
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+
+		st := &IrqStat{Name: strings.TrimSuffix(fields[0], ":"), PerCpu: make([]uint64, ncpu)}
+		i := 1
+		for ; i < len(fields) && i <= ncpu; i++ {
+			v, err := strconv.ParseUint(fields[i], 10, 64)
+			if err != nil {
+				break
+			}
+			st.PerCpu[i-1] = v
+		}
+
+		if i < len(fields) {
+			st.Device = fields[len(fields)-1]
+		}
+		ret = append(ret, st)
+	}
+
+	return ret, ncpu, scanner.Err()
+}
+
+// irqType groups numbered irqs by their device without the queue number,
+// and keeps the name of the others, e.g. NMI, LOC
+func irqType(st *IrqStat) string {
+	if _, err := strconv.Atoi(st.Name); err != nil || st.Device == "" {
+		return st.Name
+	}
+
+	device := st.Device
+	for {
+		loc := irqQueueSuffix.FindStringIndex(device)
+		if loc == nil {
+			break
+		}
+		end := loc[0]
+		if device[end] >= '0' && device[end] <= '9' {
+			// the number of nvme0q12
+			end++
+		}
+		if end == 0 {
+			break
+		}
+		device = device[:end]
+	}
+	return strings.Replace(device, ",", "_", -1)
+}
+
+func sumIrqStats(stats []*IrqStat, ncpu int, typeOf func(*IrqStat) string) (map[string]uint64, map[string][]uint64, []uint64) {
+	byType := make(map[string]uint64)
+	byTypePerCpu := make(map[string][]uint64)
+	perCpu := make([]uint64, ncpu)
+
+	for _, st := range stats {
+		t := typeOf(st)
+		if _, ok := byTypePerCpu[t]; !ok {
+			byTypePerCpu[t] = make([]uint64, ncpu)
+		}
+		for cpu, v := range st.PerCpu {
+			byType[t] += v
+			byTypePerCpu[t][cpu] += v
+			perCpu[cpu] += v
+		}
+	}
+	return byType, byTypePerCpu, perCpu
+}
+
+// InterruptMetrics reports /proc/interrupts by irq type and by cpu, and how
+// unevenly they are spread over the cpus
+func InterruptMetrics() (L []*model.MetricValue) {
+	stats, ncpu, err := ReadIrqStat("/proc/interrupts")
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	byType, _, perCpu := sumIrqStats(stats, ncpu, irqType)
+	for t, v := range byType {
+		L = append(L, CounterValue("irq.count", v, "type="+t))
+	}
+	for cpu, v := range perCpu {
+		L = append(L, CounterValue("irq.cpu.count", v, fmt.Sprintf("cpu=%d", cpu)))
+	}
+
+	if imbalance, ok := irqImbalance.Update("all", perCpu); ok {
+		L = append(L, GaugeValue("irq.imbalance", imbalance))
+	}
+
+	return
+}
+
+// SoftirqMetrics reports /proc/softirqs by type and by cpu, and how
+// unevenly every type is spread over the cpus
+func SoftirqMetrics() (L []*model.MetricValue) {
+	stats, ncpu, err := ReadIrqStat("/proc/softirqs")
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	byType, byTypePerCpu, perCpu := sumIrqStats(stats, ncpu, func(st *IrqStat) string { return st.Name })
+	for t, v := range byType {
+		L = append(L, CounterValue("softirq.count", v, "type="+t))
+		if imbalance, ok := softirqImbalance.Update(t, byTypePerCpu[t]); ok {
+			L = append(L, GaugeValue("softirq.imbalance", imbalance, "type="+t))
+		}
+	}
+	for cpu, v := range perCpu {
+		L = append(L, CounterValue("softirq.cpu.count", v, fmt.Sprintf("cpu=%d", cpu)))
+	}
+
+	return
+}
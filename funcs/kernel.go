@@ -1,9 +1,14 @@
 package funcs
 
 import (
+	"bufio"
 	"github.com/open-falcon/common/model"
 	"github.com/toolkits/nux"
+	"io/ioutil"
 	"log"
+	"os"
+	"strconv"
+	"strings"
 )
 
 func KernelMetrics() (L []*model.MetricValue) {
@@ -34,3 +39,80 @@ func KernelMetrics() (L []*model.MetricValue) {
 	L = append(L, GaugeValue("kernel.files.left", maxFiles-allocateFiles))
 	return
 }
+
+// ReadKernelStat reads the single value lines of /proc/stat, e.g.
+// processes, procs_running, procs_blocked; and the total of intr
+func ReadKernelStat() (map[string]uint64, error) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ret := make(map[string]uint64)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "cpu") {
+			continue
+		}
+		if v, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+			ret[fields[0]] = v
+		}
+	}
+
+	return ret, scanner.Err()
+}
+
+// threadCount is the number of scheduling entities, the 4th field of
+// /proc/loadavg, e.g. "0.00 0.01 0.05 1/190 1234"
+func threadCount() (uint64, error) {
+	bs, err := ioutil.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, err
+	}
+
+	fields := strings.Fields(string(bs))
+	if len(fields) < 4 {
+		return 0, nil
+	}
+	arr := strings.Split(fields[3], "/")
+	if len(arr) != 2 {
+		return 0, nil
+	}
+	return strconv.ParseUint(arr[1], 10, 64)
+}
+
+func KernelStatMetrics() (L []*model.MetricValue) {
+	stat, err := ReadKernelStat()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	// processes is the number of forks since boot
+	L = append(L, CounterValue("kernel.processes", stat["processes"]))
+	L = append(L, CounterValue("kernel.interrupts", stat["intr"]))
+	L = append(L, GaugeValue("kernel.procs.running", stat["procs_running"]))
+	L = append(L, GaugeValue("kernel.procs.blocked", stat["procs_blocked"]))
+
+	if entropy, err := readUintFile("/proc/sys/kernel/random/entropy_avail"); err == nil {
+		L = append(L, GaugeValue("kernel.entropy.avail", entropy))
+	}
+
+	threads, err := threadCount()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	L = append(L, GaugeValue("kernel.threads", threads))
+
+	// every thread takes a pid
+	if pidMax, err := readUintFile("/proc/sys/kernel/pid_max"); err == nil && pidMax > 0 {
+		L = append(L, GaugeValue("kernel.pid.max", pidMax))
+		L = append(L, GaugeValue("kernel.pid.used.percent", float64(threads)*100.0/float64(pidMax)))
+	}
+
+	return
+}
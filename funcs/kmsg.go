@@ -0,0 +1,315 @@
+package funcs
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/open-falcon/agent/g"
+	"github.com/open-falcon/common/model"
+	"github.com/toolkits/file"
+)
+
+const defaultKmsgStateFile = "var/kmsg.json"
+
+// DefaultKmsgPatterns are the categories of kernel messages counted, a
+// pattern of collector.kmsgPatterns replaces the one of the same category,
+// a blank one disables it. The named group device is a tag.
+var DefaultKmsgPatterns = map[string]string{
+	"io_error":    `I/O error,? (?:on )?dev (?P<device>[\w.-]+)|Buffer I/O error on (?:dev|device) (?P<device>[\w.-]+)`,
+	"fs_error":    `EXT[234]-fs error \(device (?P<device>[^)]+)\)|XFS \((?P<device>[^)]+)\): (?:metadata I/O error|Corruption)|BTRFS (?:error|critical) \(device (?P<device>[^)]+)\)`,
+	"mce":         `\[Hardware Error\]|Machine check events logged|EDAC .*(?:CE|UE) `,
+	"oom":         `Out of memory: Kill|Memory cgroup out of memory: Kill`,
+	"segfault":    `segfault at|general protection fault|trap divide error|trap invalid opcode`,
+	"hung_task":   `blocked for more than \d+ seconds`,
+	"lockup":      `soft lockup|hard LOCKUP|rcu_sched self-detected stall|rcu: INFO: rcu_sched`,
+	"nic_timeout": `NETDEV WATCHDOG: (?P<device>\w+)`,
+}
+
+// KmsgEvent is a classified kernel message, forwarded to
+// collector.kmsgEventUrl
+type KmsgEvent struct {
+	Endpoint string `json:"endpoint"`
+	Category string `json:"category"`
+	Device   string `json:"device,omitempty"`
+	Priority int    `json:"priority"`
+	Seq      uint64 `json:"seq"`
+	// seconds since boot
+	Uptime  float64 `json:"uptime"`
+	Message string  `json:"message"`
+}
+
+type kmsgState struct {
+	BootId string `json:"boot_id"`
+	Seq    uint64 `json:"seq"`
+}
+
+var (
+	kmsgFd    = -1
+	kmsgLast  *kmsgState
+	kmsgLock  = new(sync.Mutex)
+	kmsgError bool
+
+	// category => count, category/device => count
+	kmsgCounts       = make(map[string]uint64)
+	kmsgDeviceCounts = make(map[[2]string]uint64)
+
+	kmsgPatterns       map[string]*regexp.Regexp
+	kmsgPatternsConfig *g.GlobalConfig
+)
+
+func kmsgStateFile() string {
+	path := defaultKmsgStateFile
+	if c := g.Config().Collector; c != nil && c.KmsgStateFile != "" {
+		path = c.KmsgStateFile
+	}
+
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(g.Root, path)
+	}
+	return path
+}
+
+// KmsgPatterns are the compiled patterns by category, compiled again only
+// after the configuration is reloaded
+func KmsgPatterns() map[string]*regexp.Regexp {
+	c := g.Config()
+	if c == kmsgPatternsConfig {
+		return kmsgPatterns
+	}
+
+	patterns := make(map[string]string)
+	for category, pattern := range DefaultKmsgPatterns {
+		patterns[category] = pattern
+	}
+	if c.Collector != nil {
+		for category, pattern := range c.Collector.KmsgPatterns {
+			patterns[category] = pattern
+		}
+	}
+
+	kmsgPatterns = make(map[string]*regexp.Regexp)
+	for category, pattern := range patterns {
+		if pattern == "" {
+			continue
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			log.Println("invalid collector.kmsgPatterns", category, ":", err)
+			if pattern = DefaultKmsgPatterns[category]; pattern == "" {
+				continue
+			}
+			re = regexp.MustCompile(pattern)
+		}
+		kmsgPatterns[category] = re
+	}
+
+	kmsgPatternsConfig = c
+	return kmsgPatterns
+}
+
+func bootId() string {
+	bs, err := ioutil.ReadFile("/proc/sys/kernel/random/boot_id")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(bs))
+}
+
+// openKmsg opens /dev/kmsg, going on after the message seen last if the
+// host did not reboot since, and skipping the messages already there if the
+// agent never read it
+func openKmsg() bool {
+	fd, err := syscall.Open("/dev/kmsg", syscall.O_RDONLY|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		if !kmsgError {
+			log.Println("open /dev/kmsg fail", err)
+			kmsgError = true
+		}
+		return false
+	}
+
+	kmsgLast = &kmsgState{BootId: bootId()}
+	path := kmsgStateFile()
+	if !file.IsExist(path) {
+		if _, err := syscall.Seek(fd, 0, io.SeekEnd); err != nil {
+			log.Println("seek /dev/kmsg fail", err)
+		}
+	} else if bs, err := ioutil.ReadFile(path); err == nil {
+		var last kmsgState
+		if err := json.Unmarshal(bs, &last); err == nil && last.BootId == kmsgLast.BootId {
+			kmsgLast.Seq = last.Seq
+		}
+	}
+
+	kmsgFd = fd
+	kmsgError = false
+	return true
+}
+
+func saveKmsgState() {
+	bs, err := json.Marshal(kmsgLast)
+	if err != nil {
+		log.Println("json.Marshal kmsg state fail:", err)
+		return
+	}
+
+	path := kmsgStateFile()
+	file.InsureDir(filepath.Dir(path))
+	tmp := path + ".tmp"
+	if err = ioutil.WriteFile(tmp, bs, 0644); err != nil {
+		log.Println("write", tmp, "fail:", err)
+		return
+	}
+
+	if err = os.Rename(tmp, path); err != nil {
+		log.Println("rename", tmp, "fail:", err)
+	}
+}
+
+// KmsgMetrics classifies the kernel messages logged since the last round,
+// and counts them by category, and by category and device
+func KmsgMetrics() (L []*model.MetricValue) {
+	kmsgLock.Lock()
+	defer kmsgLock.Unlock()
+
+	if kmsgFd < 0 && !openKmsg() {
+		return
+	}
+
+	patterns := KmsgPatterns()
+	events := []*KmsgEvent{}
+	buf := make([]byte, 8192)
+	for {
+		// every read returns one message
+		n, err := syscall.Read(kmsgFd, buf)
+		if err == syscall.EPIPE {
+			// overwritten before read, go on with the oldest one left
+			continue
+		}
+		if err != nil || n <= 0 {
+			if err != nil && err != syscall.EAGAIN {
+				log.Println("read /dev/kmsg fail", err)
+			}
+			break
+		}
+
+		prio, seq, uptime, msg, ok := parseKmsg(buf[:n])
+		if !ok || (kmsgLast.Seq > 0 && seq <= kmsgLast.Seq) {
+			continue
+		}
+		kmsgLast.Seq = seq
+
+		for category, re := range patterns {
+			m := re.FindStringSubmatch(msg)
+			if m == nil {
+				continue
+			}
+
+			device := ""
+			for i, name := range re.SubexpNames() {
+				if name == "device" && m[i] != "" {
+					device = SanitizeTagValue(m[i])
+				}
+			}
+
+			kmsgCounts[category]++
+			if device != "" {
+				kmsgDeviceCounts[[2]string{category, device}]++
+			}
+			events = append(events, &KmsgEvent{Category: category, Device: device, Priority: prio, Seq: seq, Uptime: uptime, Message: msg})
+		}
+	}
+	saveKmsgState()
+
+	if len(events) > 0 {
+		go forwardKmsgEvents(events)
+	}
+
+	for category := range patterns {
+		L = append(L, CounterValue("kmsg.errors", kmsgCounts[category], "category="+category))
+	}
+	for key, cnt := range kmsgDeviceCounts {
+		if _, ok := patterns[key[0]]; ok {
+			L = append(L, CounterValue("kmsg.device.errors", cnt, "category="+key[0]+",device="+key[1]))
+		}
+	}
+	return
+}
+
+// parseKmsg parses a record of /dev/kmsg,
+// 'priority,seq,usec,flags[,...];message' and lines of ' KEY=value'
+func parseKmsg(record []byte) (prio int, seq uint64, uptime float64, msg string, ok bool) {
+	i := bytes.IndexByte(record, ';')
+	if i < 0 {
+		return
+	}
+
+	fields := strings.Split(string(record[:i]), ",")
+	if len(fields) < 3 {
+		return
+	}
+
+	var err error
+	if prio, err = strconv.Atoi(fields[0]); err != nil {
+		return
+	}
+	if seq, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
+		return
+	}
+	usec, err := strconv.ParseUint(fields[2], 10, 64)
+	if err != nil {
+		return
+	}
+
+	msg = string(record[i+1:])
+	if j := strings.IndexByte(msg, '\n'); j >= 0 {
+		msg = msg[:j]
+	}
+	return prio & 7, seq, float64(usec) / 1e6, msg, true
+}
+
+// forwardKmsgEvents posts the events as a json array to
+// collector.kmsgEventUrl if it is set
+func forwardKmsgEvents(events []*KmsgEvent) {
+	c := g.Config().Collector
+	if c == nil || c.KmsgEventUrl == "" {
+		return
+	}
+
+	hostname, err := g.Hostname()
+	if err != nil {
+		hostname = "None"
+	}
+	for _, e := range events {
+		e.Endpoint = hostname
+	}
+
+	bs, err := json.Marshal(events)
+	if err != nil {
+		log.Println("json.Marshal kmsg events fail:", err)
+		return
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(c.KmsgEventUrl, "application/json", bytes.NewReader(bs))
+	if err != nil {
+		log.Println("post kmsg events to", c.KmsgEventUrl, "fail", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		log.Println("post kmsg events to", c.KmsgEventUrl, "fail, status", resp.StatusCode)
+	}
+}
@@ -0,0 +1,430 @@
+package funcs
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/open-falcon/agent/g"
+	"github.com/open-falcon/common/model"
+	"github.com/toolkits/file"
+)
+
+const (
+	defaultLogStateFile = "var/logtail.json"
+	// at most so much of a log is read in a round, the rest in the next ones
+	logMaxRead = 64 << 20
+	// a longer line is dropped
+	logMaxLine = 1 << 20
+)
+
+// where a log was read up to, persisted so that a restarted agent goes on
+// from there
+type logOffset struct {
+	Ino    uint64 `json:"ino"`
+	Offset int64  `json:"offset"`
+}
+
+// logTailer follows a log, keeping it open so that what is appended to it
+// after it is rotated away is still read
+type logTailer struct {
+	path   string
+	f      *os.File
+	ino    uint64
+	offset int64
+	// the last line, not ended yet
+	partial []byte
+	started bool
+}
+
+type logSeries struct {
+	metric  string
+	tags    string
+	counter bool
+	value   float64
+}
+
+var (
+	logTailers = make(map[string]*logTailer)
+	// metric/tags => series
+	logSeriesMap = make(map[string]*logSeries)
+	// metric => tag combinations, to cap them by maxSeries
+	logTagSets = make(map[string]map[string]bool)
+	logCapped  = make(map[string]bool)
+	// offsets of the last run
+	logOffsets    map[string]logOffset
+	logLock       = new(sync.Mutex)
+	logLoadedOnce sync.Once
+)
+
+func logStateFile() string {
+	path := defaultLogStateFile
+	if c := g.Config().Collector; c != nil && c.LogStateFile != "" {
+		path = c.LogStateFile
+	}
+
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(g.Root, path)
+	}
+	return path
+}
+
+func loadLogOffsets() {
+	logOffsets = make(map[string]logOffset)
+
+	path := logStateFile()
+	if !file.IsExist(path) {
+		return
+	}
+
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Println("read", path, "fail:", err)
+		return
+	}
+
+	if err = json.Unmarshal(bs, &logOffsets); err != nil {
+		log.Println("parse", path, "fail:", err)
+		logOffsets = make(map[string]logOffset)
+	}
+}
+
+func saveLogOffsets() {
+	offsets := make(map[string]logOffset, len(logTailers))
+	for path, t := range logTailers {
+		if t.f != nil {
+			// the partial line is read again
+			offsets[path] = logOffset{Ino: t.ino, Offset: t.offset - int64(len(t.partial))}
+		}
+	}
+
+	bs, err := json.Marshal(offsets)
+	if err != nil {
+		log.Println("json.Marshal log offsets fail:", err)
+		return
+	}
+
+	path := logStateFile()
+	file.InsureDir(filepath.Dir(path))
+	tmp := path + ".tmp"
+	if err = ioutil.WriteFile(tmp, bs, 0644); err != nil {
+		log.Println("write", tmp, "fail:", err)
+		return
+	}
+
+	if err = os.Rename(tmp, path); err != nil {
+		log.Println("rename", tmp, "fail:", err)
+	}
+}
+
+// LogMetrics reads what is appended to the logs of collector.logs since the
+// last round and applies their rules to every line
+func LogMetrics() (L []*model.MetricValue) {
+	logs := g.LocalLogs()
+
+	logLock.Lock()
+	defer logLock.Unlock()
+
+	if len(logs) == 0 && len(logTailers) == 0 {
+		return
+	}
+	logLoadedOnce.Do(loadLogOffsets)
+
+	wanted := make(map[string]bool, len(logs))
+	metrics := make(map[string]bool)
+	for _, lc := range logs {
+		wanted[lc.Path] = true
+		for _, rule := range lc.Rules {
+			metrics[rule.Metric] = true
+			if rule.Type == "histogram" {
+				metrics[rule.Metric+".bucket"] = true
+				metrics[rule.Metric+".sum"] = true
+				metrics[rule.Metric+".count"] = true
+			}
+		}
+
+		t, ok := logTailers[lc.Path]
+		if !ok {
+			t = &logTailer{path: lc.Path}
+			logTailers[lc.Path] = t
+		}
+
+		rules := lc.Rules
+		t.read(func(line string) {
+			applyLogRules(rules, line)
+		})
+	}
+
+	for path, t := range logTailers {
+		if !wanted[path] {
+			t.close()
+			delete(logTailers, path)
+		}
+	}
+	for key, s := range logSeriesMap {
+		if !metrics[s.metric] {
+			delete(logSeriesMap, key)
+		}
+	}
+	for metric := range logTagSets {
+		if !metrics[metric] {
+			delete(logTagSets, metric)
+			delete(logCapped, metric)
+		}
+	}
+
+	saveLogOffsets()
+
+	for _, s := range logSeriesMap {
+		if s.counter {
+			L = append(L, CounterValue(s.metric, s.value, s.tags))
+		} else {
+			L = append(L, GaugeValue(s.metric, s.value, s.tags))
+		}
+	}
+	return
+}
+
+func fileIno(fi os.FileInfo) uint64 {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Ino)
+	}
+	return 0
+}
+
+// open opens the log, at the offset of the last run if it is the same
+// file, from the start if it is rotated since, and at the end if it was
+// never read, so that the history is not counted
+func (this *logTailer) open(resume bool) bool {
+	f, err := os.Open(this.path)
+	if err != nil {
+		return false
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return false
+	}
+
+	offset := int64(0)
+	if resume {
+		last, ok := logOffsets[this.path]
+		switch {
+		case !ok:
+			offset = fi.Size()
+		case last.Ino == fileIno(fi) && last.Offset <= fi.Size():
+			offset = last.Offset
+		}
+	}
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		log.Println("seek", this.path, "fail", err)
+		f.Close()
+		return false
+	}
+
+	this.f, this.ino, this.offset, this.partial = f, fileIno(fi), offset, nil
+	return true
+}
+
+func (this *logTailer) close() {
+	if this.f != nil {
+		this.f.Close()
+		this.f = nil
+	}
+}
+
+func (this *logTailer) read(f func(line string)) {
+	if this.f == nil {
+		// resuming only the first time, a log showing up later is new
+		resume := !this.started
+		this.started = true
+		if !this.open(resume) {
+			return
+		}
+	}
+
+	// what is left of the file, which may be rotated away
+	if !this.readToEnd(f) {
+		return
+	}
+
+	fi, err := os.Stat(this.path)
+	if err != nil {
+		// removed, maybe to be created again
+		return
+	}
+
+	if fileIno(fi) != this.ino {
+		// rotated
+		this.close()
+		if this.open(false) {
+			this.readToEnd(f)
+		}
+	} else if fi.Size() < this.offset {
+		// truncated, e.g. by copytruncate
+		if _, err := this.f.Seek(0, io.SeekStart); err == nil {
+			this.offset, this.partial = 0, nil
+			this.readToEnd(f)
+		}
+	}
+}
+
+// readToEnd tells whether the end is reached, or else logMaxRead is read
+func (this *logTailer) readToEnd(f func(line string)) bool {
+	buf := make([]byte, 64*1024)
+	var read int64
+	for read < logMaxRead {
+		n, err := this.f.Read(buf)
+		read += int64(n)
+		this.offset += int64(n)
+
+		data := buf[:n]
+		for {
+			i := bytes.IndexByte(data, '\n')
+			if i < 0 {
+				break
+			}
+
+			line := data[:i]
+			if len(this.partial) > 0 {
+				line = append(this.partial, line...)
+				this.partial = nil
+			}
+			f(strings.TrimSuffix(string(line), "\r"))
+			data = data[i+1:]
+		}
+		this.partial = append(this.partial, data...)
+		if len(this.partial) > logMaxLine {
+			log.Println("line of", this.path, "longer than", logMaxLine, "dropped")
+			this.partial = nil
+		}
+
+		if err != nil {
+			if err != io.EOF {
+				log.Println("read", this.path, "fail", err)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// applyLogRules updates the series of every rule matching a line
+func applyLogRules(rules []*g.LogRule, line string) {
+	for _, rule := range rules {
+		m := rule.Re.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+
+		tags := make(map[string]string, len(rule.TagMap))
+		for k, v := range rule.TagMap {
+			tags[k] = v
+		}
+
+		value, hasGroup, hasValue := 1.0, false, false
+		for i, name := range rule.Re.SubexpNames() {
+			if name == "value" {
+				hasGroup = true
+			}
+			if name == "" || m[i] == "" {
+				continue
+			}
+			if name == "value" {
+				v, err := strconv.ParseFloat(m[i], 64)
+				if err != nil {
+					continue
+				}
+				value, hasValue = v, true
+				continue
+			}
+			tags[name] = SanitizeTagValue(m[i])
+		}
+
+		// a line without a number where one is expected is not counted
+		if hasGroup && !hasValue {
+			continue
+		}
+
+		tagStr := sortedTags(tags)
+		if !logTagSetAllowed(rule, tagStr) {
+			continue
+		}
+		switch rule.Type {
+		case "counter":
+			logSeriesOf(rule.Metric, tagStr, true).value += value
+		case "gauge":
+			logSeriesOf(rule.Metric, tagStr, false).value = value
+		case "histogram":
+			for _, b := range rule.Buckets {
+				s := logSeriesOf(rule.Metric+".bucket", joinTags(tagStr, "le="+strconv.FormatFloat(b, 'g', -1, 64)), true)
+				if value <= b {
+					s.value++
+				}
+			}
+			logSeriesOf(rule.Metric+".bucket", joinTags(tagStr, "le=inf"), true).value++
+			logSeriesOf(rule.Metric+".sum", tagStr, true).value += value
+			logSeriesOf(rule.Metric+".count", tagStr, true).value++
+		}
+	}
+}
+
+// logTagSetAllowed tells whether a tag combination is one of the first
+// maxSeries of a rule, logging the first one dropped
+func logTagSetAllowed(rule *g.LogRule, tags string) bool {
+	sets, ok := logTagSets[rule.Metric]
+	if !ok {
+		sets = make(map[string]bool)
+		logTagSets[rule.Metric] = sets
+	}
+	if sets[tags] {
+		return true
+	}
+
+	if len(sets) >= rule.MaxSeries {
+		if !logCapped[rule.Metric] {
+			log.Println(rule.Metric, "has", rule.MaxSeries, "tag combinations already, dropped", tags)
+			logCapped[rule.Metric] = true
+		}
+		return false
+	}
+	sets[tags] = true
+	return true
+}
+
+func logSeriesOf(metric, tags string, counter bool) *logSeries {
+	key := metric + "/" + tags
+	s, ok := logSeriesMap[key]
+	if !ok {
+		s = &logSeries{metric: metric, tags: tags, counter: counter}
+		logSeriesMap[key] = s
+	}
+	return s
+}
+
+func sortedTags(tags map[string]string) string {
+	kvs := make([]string, 0, len(tags))
+	for k, v := range tags {
+		kvs = append(kvs, k+"="+v)
+	}
+	sort.Strings(kvs)
+	return strings.Join(kvs, ",")
+}
+
+func joinTags(a, b string) string {
+	if a == "" {
+		return b
+	}
+	return a + "," + b
+}
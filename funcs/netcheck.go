@@ -0,0 +1,201 @@
+package funcs
+
+import (
+	"context"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/open-falcon/agent/g"
+	"github.com/open-falcon/common/model"
+)
+
+// at most so many tcp connects or dns lookups are done at the same time
+const netCheckWorkers = 8
+
+// AllNetChecks are the net.tcp.connect and dns.resolve of the local config
+// and of hbs
+func AllNetChecks() (map[string]*g.TcpCheck, map[string]*g.DnsCheck) {
+	localTcps, localDnss := g.LocalNetChecks()
+	remoteTcps, remoteDnss := g.ReportNetChecks()
+
+	tcps := make(map[string]*g.TcpCheck, len(localTcps)+len(remoteTcps))
+	for tags, c := range localTcps {
+		tcps[tags] = c
+	}
+	for tags, c := range remoteTcps {
+		tcps[tags] = c
+	}
+
+	dnss := make(map[string]*g.DnsCheck, len(localDnss)+len(remoteDnss))
+	for tags, c := range localDnss {
+		dnss[tags] = c
+	}
+	for tags, c := range remoteDnss {
+		dnss[tags] = c
+	}
+
+	return tcps, dnss
+}
+
+func TcpConnectMetrics() (L []*model.MetricValue) {
+	m, _ := AllNetChecks()
+	checks := []*g.TcpCheck{}
+	for _, c := range m {
+		checks = append(checks, c)
+	}
+	sz := len(checks)
+	if sz == 0 {
+		return
+	}
+
+	durations := make([]time.Duration, sz)
+	errs := make([]error, sz)
+	runParallel(sz, netCheckWorkers, func(i int) {
+		c := checks[i]
+		start := time.Now()
+		conn, err := net.DialTimeout("tcp", c.Addr, time.Duration(c.Timeout)*time.Second)
+		if err != nil {
+			errs[i] = err
+			return
+		}
+		durations[i] = time.Since(start)
+		conn.Close()
+	})
+
+	for i, c := range checks {
+		if errs[i] != nil {
+			log.Println("connect", c.Addr, "failed:", errs[i])
+			L = append(L, GaugeValue(g.NET_TCP_CONNECT, 0, c.Tags))
+			continue
+		}
+		L = append(L, GaugeValue(g.NET_TCP_CONNECT, 1, c.Tags))
+		L = append(L, GaugeValue("net.tcp.connect.time", durationMs(durations[i]), c.Tags))
+	}
+	return
+}
+
+func DnsResolveMetrics() (L []*model.MetricValue) {
+	_, m := AllNetChecks()
+	checks := []*g.DnsCheck{}
+	for _, c := range m {
+		checks = append(checks, c)
+	}
+	sz := len(checks)
+	if sz == 0 {
+		return
+	}
+
+	answers := make([][]string, sz)
+	durations := make([]time.Duration, sz)
+	errs := make([]error, sz)
+	runParallel(sz, netCheckWorkers, func(i int) {
+		start := time.Now()
+		answers[i], errs[i] = resolve(checks[i])
+		durations[i] = time.Since(start)
+	})
+
+	for i, c := range checks {
+		ok := errs[i] == nil && len(answers[i]) > 0
+		if errs[i] != nil {
+			log.Println("resolve", c.Type, c.Name, "failed:", errs[i])
+		}
+
+		L = append(L, GaugeValue(g.DNS_RESOLVE, boolToInt(ok), c.Tags))
+		L = append(L, GaugeValue("dns.resolve.time", durationMs(durations[i]), c.Tags))
+		L = append(L, GaugeValue("dns.resolve.answers", len(answers[i]), c.Tags))
+		if c.Expect != "" {
+			L = append(L, GaugeValue("dns.resolve.match", boolToInt(hasAnswer(answers[i], c.Expect)), c.Tags))
+		}
+	}
+	return
+}
+
+// resolve looks up a DnsCheck, with the resolver of the system, or with
+// its server
+func resolve(c *g.DnsCheck) ([]string, error) {
+	r := net.DefaultResolver
+	if c.Server != "" {
+		r = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, c.Server)
+			},
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(c.Timeout)*time.Second)
+	defer cancel()
+
+	var ret []string
+	switch c.Type {
+	case "A", "AAAA":
+		// only the asked family is queried
+		network := "ip4"
+		if c.Type == "AAAA" {
+			network = "ip6"
+		}
+		ips, err := r.LookupIP(ctx, network, c.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			ret = append(ret, ip.String())
+		}
+	case "CNAME":
+		cname, err := r.LookupCNAME(ctx, c.Name)
+		if err != nil {
+			return nil, err
+		}
+		// the name itself is returned if it has no CNAME record
+		if !sameName(cname, c.Name) {
+			ret = append(ret, cname)
+		}
+	case "MX":
+		mxs, err := r.LookupMX(ctx, c.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, mx := range mxs {
+			ret = append(ret, mx.Host)
+		}
+	case "NS":
+		nss, err := r.LookupNS(ctx, c.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, ns := range nss {
+			ret = append(ret, ns.Host)
+		}
+	case "TXT":
+		return r.LookupTXT(ctx, c.Name)
+	case "SRV":
+		_, srvs, err := r.LookupSRV(ctx, "", "", c.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, srv := range srvs {
+			ret = append(ret, srv.Target)
+		}
+	case "PTR":
+		return r.LookupAddr(ctx, c.Name)
+	}
+	return ret, nil
+}
+
+// hasAnswer tells whether expect is one of the answers
+func hasAnswer(answers []string, expect string) bool {
+	for _, answer := range answers {
+		if sameName(answer, expect) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameName compares names without case and the trailing dot
+func sameName(a, b string) bool {
+	return strings.EqualFold(strings.TrimSuffix(a, "."), strings.TrimSuffix(b, "."))
+}
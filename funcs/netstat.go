@@ -2,10 +2,10 @@ package funcs
 
 import (
 	"github.com/open-falcon/common/model"
-	"github.com/toolkits/nux"
 	"log"
 )
 
+// USES is the default allowlist of TcpExt
 var USES = map[string]struct{}{
 	"PruneCalled":        struct{}{},
 	"LockDroppedIcmps":   struct{}{},
@@ -32,25 +32,14 @@ var USES = map[string]struct{}{
 	"TCPMinTTLDrop":      struct{}{},
 }
 
+// NetstatMetrics reports /proc/net/netstat as $section.$key, e.g. TcpExt.TW,
+// without the snmp. prefix of SnmpMetrics, as TcpExt.* was always reported
 func NetstatMetrics() (L []*model.MetricValue) {
-	tcpExts, err := nux.Netstat("TcpExt")
-
+	netstat, err := ReadSnmpFile("/proc/net/netstat")
 	if err != nil {
 		log.Println(err)
 		return
 	}
 
-	cnt := len(tcpExts)
-	if cnt == 0 {
-		return
-	}
-
-	for key, val := range tcpExts {
-		if _, ok := USES[key]; !ok {
-			continue
-		}
-		L = append(L, CounterValue("TcpExt."+key, val))
-	}
-
-	return
+	return snmpValues("", netstat, snmpAllowlist())
 }
@@ -0,0 +1,26 @@
+package funcs
+
+import (
+	"sync"
+)
+
+// runParallel calls f(0)..f(n-1) in at most workers goroutines, and returns
+// after all of them are done
+func runParallel(n, workers int, f func(i int)) {
+	ch := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range ch {
+				f(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		ch <- i
+	}
+	close(ch)
+	wg.Wait()
+}
@@ -0,0 +1,96 @@
+package funcs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// PidStat is the part of /proc/[pid]/stat the agent uses, see proc(5)
+type PidStat struct {
+	Pid        int
+	Comm       string
+	State      byte
+	Ppid       int
+	Utime      uint64
+	Stime      uint64
+	NumThreads int64
+	// clock ticks since boot
+	StartTime uint64
+	Vsize     uint64
+	// in pages
+	Rss        int64
+	ExitSignal int
+	// wait status of a zombie, since linux 3.5
+	ExitCode int
+}
+
+// ListPids lists the pids under /proc
+func ListPids() ([]int, error) {
+	f, err := os.Open("/proc")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	pids := make([]int, 0, len(names))
+	for _, name := range names {
+		if pid, err := strconv.Atoi(name); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
+
+func ReadPidStat(pid int) (*PidStat, error) {
+	bs, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return nil, err
+	}
+	return ParsePidStat(string(bs))
+}
+
+// ParsePidStat parses the content of /proc/[pid]/stat. comm may contain
+// spaces and parentheses, so it is everything up to the last ')'.
+func ParsePidStat(content string) (*PidStat, error) {
+	start := strings.Index(content, "(")
+	end := strings.LastIndex(content, ")")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("bad stat: %q", content)
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(content[:start]))
+	if err != nil {
+		return nil, fmt.Errorf("bad stat: %q", content)
+	}
+
+	// fields[0] is the 3rd field of proc(5), state
+	fields := strings.Fields(content[end+1:])
+	if len(fields) < 22 {
+		return nil, fmt.Errorf("bad stat: %q", content)
+	}
+
+	st := &PidStat{Pid: pid, Comm: content[start+1 : end], State: fields[0][0]}
+	st.Ppid, _ = strconv.Atoi(fields[1])
+	st.Utime, _ = strconv.ParseUint(fields[11], 10, 64)
+	st.Stime, _ = strconv.ParseUint(fields[12], 10, 64)
+	st.NumThreads, _ = strconv.ParseInt(fields[17], 10, 64)
+	st.StartTime, _ = strconv.ParseUint(fields[19], 10, 64)
+	st.Vsize, _ = strconv.ParseUint(fields[20], 10, 64)
+	st.Rss, _ = strconv.ParseInt(fields[21], 10, 64)
+	if len(fields) > 35 {
+		st.ExitSignal, _ = strconv.Atoi(fields[35])
+	}
+	if len(fields) > 49 {
+		st.ExitCode, _ = strconv.Atoi(fields[49])
+	}
+
+	return st, nil
+}
@@ -0,0 +1,278 @@
+package funcs
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/open-falcon/agent/g"
+	"github.com/open-falcon/common/model"
+)
+
+const (
+	// at most so many targets are pinged at the same time
+	pingWorkers = 8
+	// between two echoes to a target, the least ping allows without root
+	pingInterval = 200 * time.Millisecond
+)
+
+// identifier of the echoes of a ping, raw sockets get the replies of all
+var pingId = uint32(os.Getpid())
+
+// PingResult is the rtt of every reply of a ping
+type PingResult struct {
+	Sent int
+	Rtts []time.Duration
+}
+
+// AllPingChecks are the net.ping.loss of collector.pings and of hbs
+func AllPingChecks() map[string]*g.PingCheck {
+	local := g.LocalPings()
+	remote := g.ReportPings()
+
+	ret := make(map[string]*g.PingCheck, len(local)+len(remote))
+	for tags, c := range local {
+		ret[tags] = c
+	}
+	for tags, c := range remote {
+		ret[tags] = c
+	}
+	return ret
+}
+
+func PingMetrics() (L []*model.MetricValue) {
+	checks := []*g.PingCheck{}
+	for _, c := range AllPingChecks() {
+		checks = append(checks, c)
+	}
+	sz := len(checks)
+	if sz == 0 {
+		return
+	}
+
+	results := make([]*PingResult, sz)
+	runParallel(sz, pingWorkers, func(i int) {
+		r, err := Ping(checks[i])
+		if err != nil {
+			log.Println("ping", checks[i].Target, "failed:", err)
+		}
+		results[i] = r
+	})
+
+	for i, c := range checks {
+		r := results[i]
+		if r == nil || r.Sent == 0 {
+			L = append(L, GaugeValue(g.NET_PING_LOSS, 100, c.Tags))
+			continue
+		}
+
+		L = append(L, GaugeValue(g.NET_PING_LOSS, float64(r.Sent-len(r.Rtts))*100/float64(r.Sent), c.Tags))
+		if len(r.Rtts) == 0 {
+			continue
+		}
+
+		min, max, sum := r.Rtts[0], r.Rtts[0], time.Duration(0)
+		var jitter float64
+		for j, rtt := range r.Rtts {
+			if rtt < min {
+				min = rtt
+			}
+			if rtt > max {
+				max = rtt
+			}
+			sum += rtt
+			if j > 0 {
+				jitter += math.Abs(durationMs(rtt) - durationMs(r.Rtts[j-1]))
+			}
+		}
+
+		L = append(L, GaugeValue("net.ping.rtt.min", durationMs(min), c.Tags))
+		L = append(L, GaugeValue("net.ping.rtt.avg", durationMs(sum)/float64(len(r.Rtts)), c.Tags))
+		L = append(L, GaugeValue("net.ping.rtt.max", durationMs(max), c.Tags))
+		// the mean difference of successive rtts
+		if len(r.Rtts) > 1 {
+			L = append(L, GaugeValue("net.ping.jitter", jitter/float64(len(r.Rtts)-1), c.Tags))
+		}
+	}
+	return
+}
+
+// Ping sends Count echoes to the target one by one, waiting Timeout for
+// every reply
+func Ping(c *g.PingCheck) (*PingResult, error) {
+	ip, err := pingTarget(c.Target)
+	if err != nil {
+		return nil, err
+	}
+
+	v6 := ip.To4() == nil
+	conn, dgram, err := openIcmp(v6)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var addr net.Addr = &net.IPAddr{IP: ip}
+	if dgram {
+		addr = &net.UDPAddr{IP: ip}
+	}
+
+	echoType, replyType := byte(8), byte(0)
+	if v6 {
+		echoType, replyType = 128, 129
+	}
+
+	// the kernel replaces the id of an unprivileged socket by its own
+	id := uint16(atomic.AddUint32(&pingId, 1))
+	r := &PingResult{}
+	buf := make([]byte, 1500)
+	for seq := 0; seq < c.Count; seq++ {
+		if seq > 0 {
+			time.Sleep(pingInterval)
+		}
+
+		msg := icmpEcho(echoType, id, uint16(seq), v6)
+		start := time.Now()
+		if _, err := conn.WriteTo(msg, addr); err != nil {
+			return r, err
+		}
+		r.Sent++
+
+		conn.SetReadDeadline(start.Add(time.Duration(c.Timeout) * time.Second))
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				// timed out, lost
+				break
+			}
+			if n < 8 || buf[0] != replyType || !fromIP(from).Equal(ip) {
+				continue
+			}
+			if binary.BigEndian.Uint16(buf[6:8]) != uint16(seq) {
+				continue
+			}
+			if !dgram && binary.BigEndian.Uint16(buf[4:6]) != id {
+				continue
+			}
+
+			r.Rtts = append(r.Rtts, time.Since(start))
+			break
+		}
+	}
+
+	return r, nil
+}
+
+// openIcmp opens an unprivileged icmp socket, which net.ipv4.ping_group_range
+// must allow, or else a raw socket, which needs root or CAP_NET_RAW
+func openIcmp(v6 bool) (net.PacketConn, bool, error) {
+	family, proto, network := syscall.AF_INET, syscall.IPPROTO_ICMP, "ip4:icmp"
+	var sa syscall.Sockaddr = &syscall.SockaddrInet4{}
+	if v6 {
+		family, proto, network = syscall.AF_INET6, syscall.IPPROTO_ICMPV6, "ip6:ipv6-icmp"
+		sa = &syscall.SockaddrInet6{}
+	}
+
+	if fd, err := syscall.Socket(family, syscall.SOCK_DGRAM, proto); err == nil {
+		if err := syscall.Bind(fd, sa); err != nil {
+			syscall.Close(fd)
+			return nil, false, err
+		}
+
+		f := os.NewFile(uintptr(fd), "icmp")
+		conn, err := net.FilePacketConn(f)
+		f.Close()
+		return conn, true, err
+	}
+
+	conn, err := net.ListenPacket(network, "")
+	return conn, false, err
+}
+
+func icmpEcho(typ byte, id, seq uint16, v6 bool) []byte {
+	b := make([]byte, 8+32)
+	b[0] = typ
+	binary.BigEndian.PutUint16(b[4:6], id)
+	binary.BigEndian.PutUint16(b[6:8], seq)
+
+	// the kernel computes it for icmpv6, which covers the ip header too
+	if !v6 {
+		var sum uint32
+		for i := 0; i < len(b); i += 2 {
+			sum += uint32(b[i])<<8 | uint32(b[i+1])
+		}
+		for sum > 0xffff {
+			sum = sum>>16 + sum&0xffff
+		}
+		binary.BigEndian.PutUint16(b[2:4], ^uint16(sum))
+	}
+	return b
+}
+
+func fromIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP
+	case *net.IPAddr:
+		return a.IP
+	}
+	return nil
+}
+
+// pingTarget resolves a target, ipv4 preferred, gateway is the default
+// gateway
+func pingTarget(target string) (net.IP, error) {
+	if target == "gateway" {
+		return defaultGateway()
+	}
+
+	ips, err := net.LookupIP(target)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			return ip.To4(), nil
+		}
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no address of %s", target)
+	}
+	return ips[0], nil
+}
+
+// defaultGateway is the gateway of the default route in /proc/net/route
+func defaultGateway() (net.IP, error) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Iface Destination Gateway Flags ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+
+		ip, _, err := parseProcNetAddr(fields[2] + ":0")
+		if err != nil {
+			return nil, err
+		}
+		if !ip.Equal(net.IPv4zero) {
+			return ip, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no default gateway")
+}
@@ -1,14 +1,31 @@
 package funcs
 
 import (
-	"fmt"
 	"github.com/open-falcon/agent/g"
 	"github.com/open-falcon/common/model"
-	"github.com/toolkits/nux"
-	"github.com/toolkits/slice"
 	"log"
+	"net"
+	"syscall"
 )
 
+type portConns struct {
+	Established uint64
+	TimeWait    uint64
+	CloseWait   uint64
+	// of the listening sockets, summed over ipv4/ipv6 and SO_REUSEPORT
+	ListenQueue   uint64
+	ListenBacklog uint64
+	// a listener was read from /proc/net, which has no backlog
+	BacklogUnknown bool
+}
+
+type socketTable struct {
+	family uint8
+	proto  uint8
+}
+
+// PortMetrics checks every net.port.listen against the kernel socket tables,
+// and reports the connections and the accept queue of tcp ports.
 func PortMetrics() (L []*model.MetricValue) {
 
 	reportPorts := g.ReportPorts()
@@ -17,20 +34,119 @@ func PortMetrics() (L []*model.MetricValue) {
 		return
 	}
 
-	allListeningPorts, err := nux.ListeningPorts()
-	if err != nil {
-		log.Println(err)
-		return
-	}
-
+	tables := make(map[socketTable][]*Socket)
+	// tables which can not be listed, e.g. for lack of permission
+	failed := make(map[socketTable]bool)
 	for i := 0; i < sz; i++ {
-		tags := fmt.Sprintf("port=%d", reportPorts[i])
-		if slice.ContainsInt64(allListeningPorts, reportPorts[i]) {
-			L = append(L, GaugeValue(g.NET_PORT_LISTEN, 1, tags))
+		p := reportPorts[i]
+		proto, families := portProto(p.Proto)
+		addr := net.ParseIP(p.Addr)
+
+		listed := false
+		listening := false
+		conns := &portConns{}
+		for _, family := range families {
+			key := socketTable{family, proto}
+			sockets, ok := tables[key]
+			if !ok && !failed[key] {
+				var err error
+				if sockets, err = ListSockets(family, proto); err != nil {
+					log.Println("list sockets fail", err)
+					failed[key] = true
+				} else {
+					tables[key] = sockets
+				}
+			}
+			if failed[key] {
+				continue
+			}
+			listed = true
+
+			for _, s := range sockets {
+				if int64(s.LocalPort) != p.Port || !addrMatch(s.LocalIP, addr) {
+					continue
+				}
+
+				if proto == syscall.IPPROTO_UDP {
+					// bound and not connected
+					if s.RemotePort == 0 {
+						listening = true
+					}
+					continue
+				}
+
+				switch s.State {
+				case TCP_ESTABLISHED:
+					conns.Established++
+				case TCP_TIME_WAIT:
+					conns.TimeWait++
+				case TCP_CLOSE_WAIT:
+					conns.CloseWait++
+				case TCP_LISTEN:
+					listening = true
+					conns.ListenQueue += uint64(s.RQueue)
+					conns.ListenBacklog += uint64(s.WQueue)
+					if s.FromProc {
+						conns.BacklogUnknown = true
+					}
+				}
+			}
+		}
+
+		// nothing is known of the port, not reporting it down
+		if !listed {
+			continue
+		}
+
+		if listening {
+			L = append(L, GaugeValue(g.NET_PORT_LISTEN, 1, p.Tags))
 		} else {
-			L = append(L, GaugeValue(g.NET_PORT_LISTEN, 0, tags))
+			L = append(L, GaugeValue(g.NET_PORT_LISTEN, 0, p.Tags))
+		}
+
+		if proto == syscall.IPPROTO_TCP {
+			L = append(L, portConnMetrics(conns, p.Tags)...)
 		}
 	}
 
 	return
 }
+
+func portConnMetrics(st *portConns, tags string) (L []*model.MetricValue) {
+	L = append(L, GaugeValue("net.port.established", st.Established, tags))
+	L = append(L, GaugeValue("net.port.time_wait", st.TimeWait, tags))
+	L = append(L, GaugeValue("net.port.close_wait", st.CloseWait, tags))
+	L = append(L, GaugeValue("net.port.listen.queue", st.ListenQueue, tags))
+	if st.BacklogUnknown {
+		return
+	}
+	L = append(L, GaugeValue("net.port.listen.backlog", st.ListenBacklog, tags))
+	if st.ListenBacklog > 0 {
+		L = append(L, GaugeValue("net.port.listen.queue.percent", float64(st.ListenQueue)*100.0/float64(st.ListenBacklog), tags))
+	}
+	return
+}
+
+// portProto maps proto of net.port.listen to the socket tables to look at,
+// tcp and udp cover both ipv4 and ipv6
+func portProto(proto string) (uint8, []uint8) {
+	switch proto {
+	case "tcp6":
+		return syscall.IPPROTO_TCP, []uint8{syscall.AF_INET6}
+	case "udp":
+		return syscall.IPPROTO_UDP, []uint8{syscall.AF_INET, syscall.AF_INET6}
+	case "udp6":
+		return syscall.IPPROTO_UDP, []uint8{syscall.AF_INET6}
+	default:
+		return syscall.IPPROTO_TCP, []uint8{syscall.AF_INET, syscall.AF_INET6}
+	}
+}
+
+// addrMatch tells whether a socket bound to local accepts traffic to addr,
+// a wildcard bind accepts all
+func addrMatch(local, addr net.IP) bool {
+	if addr == nil || local == nil || local.IsUnspecified() {
+		return true
+	}
+	return local.Equal(addr)
+}
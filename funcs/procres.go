@@ -0,0 +1,408 @@
+package funcs
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/open-falcon/agent/g"
+	"github.com/open-falcon/common/model"
+	"github.com/toolkits/nux"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// USER_HZ, which is 100 on every linux the agent runs on
+const clockTicks = 100
+
+// ProcUsage is the resource usage of a process
+type ProcUsage struct {
+	Pid        int
+	Ppid       int
+	Comm       string
+	State      byte
+	ExitCode   int
+	CpuTicks   uint64
+	Rss        uint64
+	Swap       uint64
+	Vsize      uint64
+	Threads    int64
+	Fds        uint64
+	FdLimit    uint64
+	ReadBytes  uint64
+	WriteBytes uint64
+	Switches   uint64
+	StartTime  uint64
+	// since the last round, 0 if it is the first time the process is seen
+	CpuPercent    float64
+	IORate        float64
+	ReadDelta     uint64
+	WriteDelta    uint64
+	SwitchesDelta uint64
+}
+
+type procSample struct {
+	ticks     uint64
+	read      uint64
+	write     uint64
+	switches  uint64
+	startTime uint64
+	ts        time.Time
+}
+
+// the io and context switches of the processes of a proc.num, summed over
+// the rounds, so that a process going away does not lower them
+type procTotals struct {
+	read     uint64
+	write    uint64
+	switches uint64
+}
+
+// ProcSamples keeps pid => cpu ticks and io bytes of the last round, to
+// compute the rates.
+type ProcSamples struct {
+	sync.Mutex
+	m map[int]procSample
+}
+
+func NewProcSamples() *ProcSamples {
+	return &ProcSamples{m: make(map[int]procSample)}
+}
+
+var procSamples = NewProcSamples()
+
+// tags of proc.num => totals
+var (
+	procTotalsMap  = make(map[string]*procTotals)
+	procTotalsLock = new(sync.Mutex)
+)
+
+// pruneProcTotals drops the totals of proc.num no longer configured
+func pruneProcTotals(matchers map[string]*g.ProcMatcher) {
+	procTotalsLock.Lock()
+	defer procTotalsLock.Unlock()
+
+	for tags := range procTotalsMap {
+		if _, ok := matchers[tags]; !ok {
+			delete(procTotalsMap, tags)
+		}
+	}
+}
+
+// ProcRound reads /proc at most once in a collecting round, shared by the
+// collectors of processes, and remembers the cpu ticks for the next round
+// when committed.
+type ProcRound struct {
+	procs   []*nux.Proc
+	pids    []int
+	stats   map[int]*PidStat
+	usages  map[int]*ProcUsage
+	now     time.Time
+	samples *ProcSamples
+
+	// for matching, see procs.go
+	exes     map[int]string
+	uids     map[int]string
+	cgroups  map[int]string
+	pidfiles map[string]int
+}
+
+func NewProcRound(samples *ProcSamples) *ProcRound {
+	return &ProcRound{
+		stats:    make(map[int]*PidStat),
+		usages:   make(map[int]*ProcUsage),
+		now:      time.Now(),
+		samples:  samples,
+		exes:     make(map[int]string),
+		uids:     make(map[int]string),
+		cgroups:  make(map[int]string),
+		pidfiles: make(map[string]int),
+	}
+}
+
+// Procs are the processes of nux.AllProcs
+func (this *ProcRound) Procs() ([]*nux.Proc, error) {
+	if this.procs != nil {
+		return this.procs, nil
+	}
+
+	ps, err := nux.AllProcs()
+	if err != nil {
+		return nil, err
+	}
+	this.procs = ps
+	return ps, nil
+}
+
+// Pids are all pids under /proc, kernel threads included
+func (this *ProcRound) Pids() ([]int, error) {
+	if this.pids != nil {
+		return this.pids, nil
+	}
+
+	pids, err := ListPids()
+	if err != nil {
+		return nil, err
+	}
+	this.pids = pids
+	return pids, nil
+}
+
+func (this *ProcRound) Stat(pid int) (*PidStat, error) {
+	if st, ok := this.stats[pid]; ok {
+		return st, nil
+	}
+
+	st, err := ReadPidStat(pid)
+	if err != nil {
+		return nil, err
+	}
+	this.stats[pid] = st
+	return st, nil
+}
+
+func (this *ProcRound) Usage(pid int) (*ProcUsage, error) {
+	if u, ok := this.usages[pid]; ok {
+		return u, nil
+	}
+
+	st, err := this.Stat(pid)
+	if err != nil {
+		return nil, err
+	}
+	u := ReadProcUsage(st)
+
+	this.samples.Lock()
+	last, ok := this.samples.m[pid]
+	this.samples.Unlock()
+
+	// a reused pid has another start time
+	if ok && last.startTime == u.StartTime {
+		if seconds := this.now.Sub(last.ts).Seconds(); seconds > 0 {
+			if u.CpuTicks >= last.ticks {
+				u.CpuPercent = float64(u.CpuTicks-last.ticks) * 100.0 / clockTicks / seconds
+			}
+			if u.ReadBytes >= last.read && u.WriteBytes >= last.write {
+				u.ReadDelta = u.ReadBytes - last.read
+				u.WriteDelta = u.WriteBytes - last.write
+				u.IORate = float64(u.ReadDelta+u.WriteDelta) / seconds
+			}
+		}
+		if u.Switches >= last.switches {
+			u.SwitchesDelta = u.Switches - last.switches
+		}
+	}
+
+	this.usages[pid] = u
+	return u, nil
+}
+
+// Commit keeps the samples of the processes read in this round only
+func (this *ProcRound) Commit() {
+	m := make(map[int]procSample, len(this.usages))
+	for pid, u := range this.usages {
+		m[pid] = procSample{
+			ticks:     u.CpuTicks,
+			read:      u.ReadBytes,
+			write:     u.WriteBytes,
+			switches:  u.Switches,
+			startTime: u.StartTime,
+			ts:        this.now,
+		}
+	}
+
+	this.samples.Lock()
+	this.samples.m = m
+	this.samples.Unlock()
+}
+
+// ReadProcUsage reads /proc/[pid]/{status,io,limits,fd} of a process whose
+// stat is read. io and fd are only readable by the owner of the process or
+// root, they are left 0 if not.
+func ReadProcUsage(st *PidStat) *ProcUsage {
+	u := &ProcUsage{
+		Pid:       st.Pid,
+		Ppid:      st.Ppid,
+		Comm:      st.Comm,
+		State:     st.State,
+		ExitCode:  st.ExitCode,
+		CpuTicks:  st.Utime + st.Stime,
+		Rss:       uint64(st.Rss) * uint64(os.Getpagesize()),
+		Vsize:     st.Vsize,
+		Threads:   st.NumThreads,
+		StartTime: st.StartTime,
+	}
+
+	dir := fmt.Sprintf("/proc/%d", st.Pid)
+
+	if status, err := readKeyValues(dir + "/status"); err == nil {
+		u.Switches = status["voluntary_ctxt_switches"] + status["nonvoluntary_ctxt_switches"]
+		u.Swap = status["VmSwap"] * 1024
+	}
+
+	if io, err := readKeyValues(dir + "/io"); err == nil {
+		u.ReadBytes = io["read_bytes"]
+		u.WriteBytes = io["write_bytes"]
+	}
+
+	if fds, err := ioutil.ReadDir(dir + "/fd"); err == nil {
+		u.Fds = uint64(len(fds))
+	}
+
+	u.FdLimit = readNofileLimit(dir + "/limits")
+
+	return u
+}
+
+// readKeyValues reads lines of "key: number [unit]"
+func readKeyValues(path string) (map[string]uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ret := make(map[string]uint64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		idx := strings.Index(line, ":")
+		if idx < 0 {
+			continue
+		}
+
+		fields := strings.Fields(line[idx+1:])
+		if len(fields) == 0 {
+			continue
+		}
+		if v, err := strconv.ParseUint(fields[0], 10, 64); err == nil {
+			ret[line[:idx]] = v
+		}
+	}
+	return ret, scanner.Err()
+}
+
+// readNofileLimit reads the soft limit of "Max open files" in
+// /proc/[pid]/limits, 0 if unknown or unlimited
+func readNofileLimit(path string) uint64 {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "Max open files") {
+			continue
+		}
+		fields := strings.Fields(line[len("Max open files"):])
+		if len(fields) == 0 {
+			return 0
+		}
+		v, _ := strconv.ParseUint(fields[0], 10, 64)
+		return v
+	}
+	return 0
+}
+
+// systemUptime is the first field of /proc/uptime, in seconds
+func systemUptime() (float64, error) {
+	bs, err := ioutil.ReadFile("/proc/uptime")
+	if err != nil {
+		return 0, err
+	}
+
+	fields := strings.Fields(string(bs))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("/proc/uptime is blank")
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
+
+// ProcessMetrics runs the collectors of processes on one ProcRound, so that
+// /proc is walked once a round
+func ProcessMetrics() (L []*model.MetricValue) {
+	round := NewProcRound(procSamples)
+	L = append(L, ProcStateMetrics(round)...)
+	L = append(L, ProcMetrics(round)...)
+	L = append(L, TopProcMetrics(round)...)
+	round.Commit()
+	return
+}
+
+// ProcUsageMetrics reports the total usage of the processes matched by a
+// proc.num, with the same tags
+func ProcUsageMetrics(round *ProcRound, pids []int, tags string) (L []*model.MetricValue) {
+	if len(pids) == 0 {
+		return
+	}
+
+	var sum ProcUsage
+	var fdPercent float64
+	var youngest uint64
+	found := false
+
+	for _, pid := range pids {
+		u, err := round.Usage(pid)
+		if err != nil {
+			// exited
+			continue
+		}
+		found = true
+
+		sum.CpuPercent += u.CpuPercent
+		sum.Rss += u.Rss
+		sum.Vsize += u.Vsize
+		sum.Threads += u.Threads
+		sum.Fds += u.Fds
+		sum.ReadDelta += u.ReadDelta
+		sum.WriteDelta += u.WriteDelta
+		sum.SwitchesDelta += u.SwitchesDelta
+
+		if u.FdLimit > 0 {
+			if p := float64(u.Fds) * 100.0 / float64(u.FdLimit); p > fdPercent {
+				fdPercent = p
+			}
+		}
+		if u.StartTime > youngest {
+			youngest = u.StartTime
+		}
+	}
+
+	if !found {
+		return
+	}
+
+	L = append(L, GaugeValue("proc.cpu.percent", sum.CpuPercent, tags))
+	L = append(L, GaugeValue("proc.mem.rss", sum.Rss, tags))
+	L = append(L, GaugeValue("proc.mem.vsize", sum.Vsize, tags))
+	L = append(L, GaugeValue("proc.threads", sum.Threads, tags))
+	L = append(L, GaugeValue("proc.fd.count", sum.Fds, tags))
+	// of the process closest to its RLIMIT_NOFILE
+	L = append(L, GaugeValue("proc.fd.used.percent", fdPercent, tags))
+
+	procTotalsLock.Lock()
+	totals, ok := procTotalsMap[tags]
+	if !ok {
+		totals = &procTotals{}
+		procTotalsMap[tags] = totals
+	}
+	totals.read += sum.ReadDelta
+	totals.write += sum.WriteDelta
+	totals.switches += sum.SwitchesDelta
+	L = append(L, CounterValue("proc.io.read_bytes", totals.read, tags))
+	L = append(L, CounterValue("proc.io.write_bytes", totals.write, tags))
+	L = append(L, CounterValue("proc.switches", totals.switches, tags))
+	procTotalsLock.Unlock()
+
+	// of the youngest process, a restart resets it
+	if uptime, err := systemUptime(); err == nil {
+		L = append(L, GaugeValue("proc.uptime", uptime-float64(youngest)/clockTicks, tags))
+	}
+
+	return
+}
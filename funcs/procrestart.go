@@ -0,0 +1,128 @@
+package funcs
+
+import (
+	"github.com/open-falcon/agent/g"
+	"github.com/open-falcon/common/model"
+	"strings"
+	"sync"
+)
+
+// a process is identified by pid and start time, pids get reused
+type procInstance struct {
+	pid       int
+	startTime uint64
+}
+
+type procRestartState struct {
+	instances map[procInstance]bool
+	restarts  uint64
+}
+
+// tags of proc.num => processes seen in the last round
+var (
+	procRestartStates = make(map[string]*procRestartState)
+	procRestartLock   = new(sync.Mutex)
+)
+
+// ProcRestartMetrics compares the processes matching a proc.num with the
+// last round. Every process that went away and was replaced by a new one is
+// a restart, so a crash loop shows up as a rising proc.restarts while
+// proc.num stays the same. Restarts faster than the collecting interval are
+// seen as one.
+func ProcRestartMetrics(round *ProcRound, pids []int, tags string) (L []*model.MetricValue) {
+	curr := make(map[procInstance]bool, len(pids))
+	signal := 0
+	for _, pid := range pids {
+		u, err := round.Usage(pid)
+		if err != nil {
+			continue
+		}
+
+		// a zombie is dead already, its wait status is readable until reaped
+		if u.State == 'Z' {
+			if sig := u.ExitCode & 0x7f; sig != 0 && isSupervised(round, u) {
+				signal = sig
+			}
+			continue
+		}
+
+		curr[procInstance{pid: pid, startTime: u.StartTime}] = true
+	}
+
+	procRestartLock.Lock()
+	defer procRestartLock.Unlock()
+
+	state, ok := procRestartStates[tags]
+	if !ok {
+		state = &procRestartState{}
+		procRestartStates[tags] = state
+	} else {
+		gone, started := 0, 0
+		for p := range state.instances {
+			if !curr[p] {
+				gone++
+			}
+		}
+		for p := range curr {
+			if !state.instances[p] {
+				started++
+			}
+		}
+
+		// scaling up or down is not a restart
+		if gone < started {
+			state.restarts += uint64(gone)
+		} else {
+			state.restarts += uint64(started)
+		}
+	}
+	state.instances = curr
+
+	L = append(L, CounterValue("proc.restarts", state.restarts, tags))
+	if len(supervisors()) > 0 {
+		// of a crashed child of a supervisor seen in this round, 0 if none
+		L = append(L, GaugeValue("proc.exit.signal", signal, tags))
+	}
+	return
+}
+
+// isSupervised tells whether the parent of a process is one of
+// collector.supervisors
+func isSupervised(round *ProcRound, u *ProcUsage) bool {
+	names := supervisors()
+	if len(names) == 0 {
+		return false
+	}
+
+	parent, err := round.Usage(u.Ppid)
+	if err != nil {
+		return false
+	}
+
+	for _, name := range names {
+		// comm is truncated to 15 bytes
+		if name == parent.Comm || (len(parent.Comm) == 15 && strings.HasPrefix(name, parent.Comm)) {
+			return true
+		}
+	}
+	return false
+}
+
+func supervisors() []string {
+	if c := g.Config().Collector; c != nil {
+		return c.Supervisors
+	}
+	return nil
+}
+
+// pruneProcRestarts drops the states of proc.num no longer configured
+func pruneProcRestarts(matchers map[string]*g.ProcMatcher) {
+	procRestartLock.Lock()
+	defer procRestartLock.Unlock()
+
+	for tags := range procRestartStates {
+		if _, ok := matchers[tags]; !ok {
+			delete(procRestartStates, tags)
+		}
+	}
+}
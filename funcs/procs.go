@@ -1,22 +1,42 @@
 package funcs
 
 import (
+	"fmt"
 	"github.com/open-falcon/agent/g"
 	"github.com/open-falcon/common/model"
 	"github.com/toolkits/nux"
+	"io/ioutil"
 	"log"
+	"os"
+	"strconv"
 	"strings"
 )
 
-func ProcMetrics() (L []*model.MetricValue) {
+// AllProcMatchers are the proc.num of collector.procs and of hbs, hbs wins
+// if both have the same tags
+func AllProcMatchers() map[string]*g.ProcMatcher {
+	local := g.LocalProcs()
+	remote := g.ReportProcs()
 
-	reportProcs := g.ReportProcs()
+	ret := make(map[string]*g.ProcMatcher, len(local)+len(remote))
+	for tags, m := range local {
+		ret[tags] = m
+	}
+	for tags, m := range remote {
+		ret[tags] = m
+	}
+	return ret
+}
+
+func ProcMetrics(round *ProcRound) (L []*model.MetricValue) {
+
+	reportProcs := AllProcMatchers()
 	sz := len(reportProcs)
 	if sz == 0 {
 		return
 	}
 
-	ps, err := nux.AllProcs()
+	ps, err := round.Procs()
 	if err != nil {
 		log.Println(err)
 		return
@@ -25,33 +45,142 @@ func ProcMetrics() (L []*model.MetricValue) {
 	pslen := len(ps)
 
 	for tags, m := range reportProcs {
-		cnt := 0
+		pids := []int{}
 		for i := 0; i < pslen; i++ {
-			if is_a(ps[i], m) {
-				cnt++
+			if is_a(round, ps[i], m) {
+				pids = append(pids, ps[i].Pid)
 			}
 		}
 
-		L = append(L, GaugeValue(g.PROC_NUM, cnt, tags))
+		L = append(L, GaugeValue(g.PROC_NUM, len(pids), tags))
+		L = append(L, ProcUsageMetrics(round, pids, tags)...)
+		L = append(L, ProcRestartMetrics(round, pids, tags)...)
 	}
 
+	pruneProcRestarts(reportProcs)
+	pruneProcTotals(reportProcs)
 	return
 }
 
-func is_a(p *nux.Proc, m map[int]string) bool {
-	// only one kv pair
-	for key, val := range m {
-		if key == 1 {
-			// name
-			if val != p.Name {
-				return false
-			}
-		} else if key == 2 {
-			// cmdline
-			if !strings.Contains(p.Cmdline, val) {
-				return false
-			}
+func is_a(round *ProcRound, p *nux.Proc, m *g.ProcMatcher) bool {
+	if m.Name != "" && m.Name != p.Name {
+		return false
+	}
+
+	if m.Cmdline != "" && !strings.Contains(p.Cmdline, m.Cmdline) {
+		return false
+	}
+
+	if m.CmdlineRegex != nil && !m.CmdlineRegex.MatchString(p.Cmdline) {
+		return false
+	}
+
+	if m.Pidfile != "" && round.pidfile(m.Pidfile) != p.Pid {
+		return false
+	}
+
+	if m.Uid != "" && round.uid(p.Pid) != m.Uid {
+		return false
+	}
+
+	if m.Exe != "" && round.exe(p.Pid) != m.Exe {
+		return false
+	}
+
+	if m.Unit != "" || m.Cgroup != "" {
+		cgroup := round.cgroup(p.Pid)
+		if m.Unit != "" && !cgroupHasUnit(cgroup, m.Unit) {
+			return false
+		}
+		if m.Cgroup != "" && !strings.Contains(cgroup, m.Cgroup) {
+			return false
 		}
 	}
+
 	return true
 }
+
+// pidfile is the pid in a pidfile, -1 if it can not be read
+func (this *ProcRound) pidfile(path string) int {
+	if pid, ok := this.pidfiles[path]; ok {
+		return pid
+	}
+
+	pid := -1
+	if bs, err := ioutil.ReadFile(path); err == nil {
+		if v, err := strconv.Atoi(strings.TrimSpace(string(bs))); err == nil {
+			pid = v
+		}
+	}
+
+	this.pidfiles[path] = pid
+	return pid
+}
+
+// uid is the effective uid, the 2nd field of Uid in /proc/[pid]/status
+func (this *ProcRound) uid(pid int) string {
+	if uid, ok := this.uids[pid]; ok {
+		return uid
+	}
+
+	uid := ""
+	if bs, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/status", pid)); err == nil {
+		for _, line := range strings.Split(string(bs), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) > 2 && fields[0] == "Uid:" {
+				uid = fields[2]
+				break
+			}
+		}
+	}
+
+	this.uids[pid] = uid
+	return uid
+}
+
+func (this *ProcRound) exe(pid int) string {
+	if exe, ok := this.exes[pid]; ok {
+		return exe
+	}
+
+	exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+	if err == nil {
+		// the binary was replaced, e.g. by an upgrade
+		exe = strings.TrimSuffix(exe, " (deleted)")
+	}
+
+	this.exes[pid] = exe
+	return exe
+}
+
+func (this *ProcRound) cgroup(pid int) string {
+	if cgroup, ok := this.cgroups[pid]; ok {
+		return cgroup
+	}
+
+	cgroup := ""
+	if bs, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cgroup", pid)); err == nil {
+		cgroup = string(bs)
+	}
+
+	this.cgroups[pid] = cgroup
+	return cgroup
+}
+
+// cgroupHasUnit tells whether a path of /proc/[pid]/cgroup, e.g.
+// 0::/system.slice/nginx.service, is in the systemd unit
+func cgroupHasUnit(cgroup, unit string) bool {
+	for _, line := range strings.Split(cgroup, "\n") {
+		// hierarchy-ID:controller-list:cgroup-path
+		arr := strings.SplitN(line, ":", 3)
+		if len(arr) != 3 {
+			continue
+		}
+
+		path := arr[2]
+		if strings.HasSuffix(path, "/"+unit) || strings.Contains(path, "/"+unit+"/") {
+			return true
+		}
+	}
+	return false
+}
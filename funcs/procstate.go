@@ -0,0 +1,39 @@
+package funcs
+
+import (
+	"github.com/open-falcon/common/model"
+	"log"
+)
+
+// process states of /proc/[pid]/stat always reported, others only if seen
+var procStates = []string{"R", "S", "D", "Z", "T", "I"}
+
+// ProcStateMetrics counts the processes in every state, so that zombie and
+// D-state buildups are alertable
+func ProcStateMetrics(round *ProcRound) (L []*model.MetricValue) {
+	pids, err := round.Pids()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	counts := make(map[string]int)
+	for _, state := range procStates {
+		counts[state] = 0
+	}
+
+	for _, pid := range pids {
+		st, err := round.Stat(pid)
+		if err != nil {
+			// exited
+			continue
+		}
+		counts[string(st.State)]++
+	}
+
+	for state, cnt := range counts {
+		L = append(L, GaugeValue("proc.state", cnt, "state="+state))
+	}
+
+	return
+}
@@ -1,25 +1,255 @@
 package funcs
 
 import (
+	"bufio"
+	"github.com/open-falcon/agent/g"
 	"github.com/open-falcon/common/model"
-	"github.com/toolkits/nux"
 	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
 )
 
-func UdpMetrics() []*model.MetricValue {
-	udp, err := nux.Snmp("Udp")
+// DefaultSnmpSections are the keys collected of each section of
+// /proc/net/snmp, /proc/net/netstat and /proc/net/snmp6, "*" means all keys.
+// A section in collector.snmp replaces the default one, an empty list
+// disables the section.
+var DefaultSnmpSections = map[string][]string{
+	"Ip":      []string{"*"},
+	"Icmp":    []string{"*"},
+	"IcmpMsg": []string{"*"},
+	"Tcp":     []string{"*"},
+	"Udp":     []string{"*"},
+	"UdpLite": []string{"*"},
+	"IpExt":   []string{"*"},
+	"TcpExt":  usesKeys(),
+
+	"Ip6":      []string{"*"},
+	"Icmp6":    []string{"*"},
+	"Udp6":     []string{"*"},
+	"UdpLite6": []string{"*"},
+}
+
+// sections of /proc/net/snmp6, by key prefix, UdpLite6 before Udp6
+var snmp6Sections = []string{"Ip6", "Icmp6", "UdpLite6", "Udp6"}
+
+// keys reported as gauge instead of counter
+var snmpGauges = map[string]bool{
+	"Ip.Forwarding":    true,
+	"Ip.DefaultTTL":    true,
+	"Tcp.RtoAlgorithm": true,
+	"Tcp.RtoMin":       true,
+	"Tcp.RtoMax":       true,
+	"Tcp.MaxConn":      true,
+	"Tcp.CurrEstab":    true,
+}
+
+var (
+	lastRetransSegs uint64
+	lastOutSegs     uint64
+	retransLock     = new(sync.Mutex)
+)
+
+func usesKeys() []string {
+	keys := make([]string, 0, len(USES))
+	for key := range USES {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// snmpAllowlist returns the allowed keys of every section, nil for all keys
+func snmpAllowlist() map[string]map[string]bool {
+	sections := make(map[string][]string)
+	for section, keys := range DefaultSnmpSections {
+		sections[section] = keys
+	}
+	if c := g.Config().Collector; c != nil {
+		for section, keys := range c.Snmp {
+			sections[section] = keys
+		}
+	}
+
+	ret := make(map[string]map[string]bool)
+	for section, keys := range sections {
+		if len(keys) == 0 {
+			continue
+		}
+
+		allowed := make(map[string]bool)
+		for _, key := range keys {
+			if key == "*" {
+				allowed = nil
+				break
+			}
+			allowed[key] = true
+		}
+		ret[section] = allowed
+	}
+	return ret
+}
+
+func snmpAllowed(allowlist map[string]map[string]bool, section, key string) bool {
+	keys, ok := allowlist[section]
+	if !ok {
+		return false
+	}
+	return keys == nil || keys[key]
+}
+
+// ReadSnmpFile parses /proc/net/snmp or /proc/net/netstat, where every
+// section is a line of keys followed by a line of values.
+func ReadSnmpFile(path string) (map[string]map[string]int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ret := make(map[string]map[string]int64)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var keys []string
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if keys == nil || keys[0] != fields[0] {
+			if keys != nil {
+				log.Printf("%s: skip section %s without values", path, keys[0])
+			}
+			keys = fields
+			continue
+		}
+		vals := fields
+		if len(keys) < 2 || len(keys) != len(vals) {
+			log.Printf("%s: skip malformed section %s", path, keys[0])
+			keys = nil
+			continue
+		}
+
+		// a section may span several line pairs, e.g. IcmpMsg with many types
+		section := strings.TrimSuffix(keys[0], ":")
+		m, ok := ret[section]
+		if !ok {
+			m = make(map[string]int64, len(keys)-1)
+			ret[section] = m
+		}
+		for i := 1; i < len(keys); i++ {
+			m[keys[i]] = parseSnmpValue(vals[i])
+		}
+		keys = nil
+	}
+
+	return ret, scanner.Err()
+}
+
+// ReadSnmp6File parses /proc/net/snmp6, which is one "Ip6InReceives 1" per line
+func ReadSnmp6File(path string) (map[string]map[string]int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ret := make(map[string]map[string]int64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+
+		for _, section := range snmp6Sections {
+			if !strings.HasPrefix(fields[0], section) {
+				continue
+			}
+			if _, ok := ret[section]; !ok {
+				ret[section] = make(map[string]int64)
+			}
+			ret[section][fields[0][len(section):]] = parseSnmpValue(fields[1])
+			break
+		}
+	}
+
+	return ret, scanner.Err()
+}
+
+func parseSnmpValue(s string) int64 {
+	if v, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return v
+	}
+	v, _ := strconv.ParseUint(s, 10, 64)
+	return int64(v)
+}
+
+func snmpValues(prefix string, sections map[string]map[string]int64, allowlist map[string]map[string]bool) (L []*model.MetricValue) {
+	for section, m := range sections {
+		for key, val := range m {
+			if !snmpAllowed(allowlist, section, key) {
+				continue
+			}
+
+			name := section + "." + key
+			if snmpGauges[name] {
+				L = append(L, GaugeValue(prefix+name, val))
+			} else {
+				L = append(L, CounterValue(prefix+name, val))
+			}
+		}
+	}
+	return
+}
+
+// SnmpMetrics reports /proc/net/snmp and /proc/net/snmp6 as snmp.$section.$key
+func SnmpMetrics() (L []*model.MetricValue) {
+	allowlist := snmpAllowlist()
+
+	snmp, err := ReadSnmpFile("/proc/net/snmp")
 	if err != nil {
 		log.Println("read snmp fail", err)
-		return []*model.MetricValue{}
+		return
 	}
 
-	count := len(udp)
-	ret := make([]*model.MetricValue, count)
-	i := 0
-	for key, val := range udp {
-		ret[i] = CounterValue("snmp.Udp."+key, val)
-		i++
+	L = append(L, snmpValues("snmp.", snmp, allowlist)...)
+	L = append(L, tcpRetransMetrics(snmp["Tcp"])...)
+
+	// no ipv6
+	if _, err := os.Stat("/proc/net/snmp6"); err != nil {
+		return
 	}
 
-	return ret
+	snmp6, err := ReadSnmp6File("/proc/net/snmp6")
+	if err != nil {
+		log.Println("read snmp6 fail", err)
+		return
+	}
+
+	L = append(L, snmpValues("snmp.", snmp6, allowlist)...)
+	return
+}
+
+// tcpRetransMetrics is the share of retransmitted segments since the last call
+func tcpRetransMetrics(tcp map[string]int64) []*model.MetricValue {
+	retrans, ok1 := tcp["RetransSegs"]
+	out, ok2 := tcp["OutSegs"]
+	if !ok1 || !ok2 {
+		return nil
+	}
+
+	retransLock.Lock()
+	defer retransLock.Unlock()
+
+	lastRetrans, lastOut := lastRetransSegs, lastOutSegs
+	lastRetransSegs, lastOutSegs = uint64(retrans), uint64(out)
+
+	if lastOut == 0 || uint64(out) <= lastOut || uint64(retrans) < lastRetrans {
+		return nil
+	}
+
+	percent := float64(uint64(retrans)-lastRetrans) * 100.0 / float64(uint64(out)-lastOut)
+	return []*model.MetricValue{GaugeValue("tcp.retrans.percent", percent)}
 }
@@ -0,0 +1,270 @@
+package funcs
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// states of include/net/tcp_states.h
+const (
+	TCP_ESTABLISHED  = 1
+	TCP_SYN_SENT     = 2
+	TCP_SYN_RECV     = 3
+	TCP_FIN_WAIT1    = 4
+	TCP_FIN_WAIT2    = 5
+	TCP_TIME_WAIT    = 6
+	TCP_CLOSE        = 7
+	TCP_CLOSE_WAIT   = 8
+	TCP_LAST_ACK     = 9
+	TCP_LISTEN       = 10
+	TCP_CLOSING      = 11
+	TCP_NEW_SYN_RECV = 12
+)
+
+var TcpStates = map[uint8]string{
+	TCP_ESTABLISHED: "ESTABLISHED",
+	TCP_SYN_SENT:    "SYN_SENT",
+	TCP_SYN_RECV:    "SYN_RECV",
+	TCP_FIN_WAIT1:   "FIN_WAIT1",
+	TCP_FIN_WAIT2:   "FIN_WAIT2",
+	TCP_TIME_WAIT:   "TIME_WAIT",
+	TCP_CLOSE:       "CLOSE",
+	TCP_CLOSE_WAIT:  "CLOSE_WAIT",
+	TCP_LAST_ACK:    "LAST_ACK",
+	TCP_LISTEN:      "LISTEN",
+	TCP_CLOSING:     "CLOSING",
+}
+
+// Socket is an entry of the kernel socket tables. For a listening tcp socket
+// RQueue is the current accept queue length and WQueue the backlog; WQueue
+// is 0 and FromProc set if the socket was read from /proc/net.
+type Socket struct {
+	Family     uint8
+	Proto      uint8
+	State      uint8
+	LocalIP    net.IP
+	LocalPort  uint16
+	RemoteIP   net.IP
+	RemotePort uint16
+	RQueue     uint32
+	WQueue     uint32
+	Uid        uint32
+	Inode      uint32
+	FromProc   bool
+}
+
+const (
+	sockDiagByFamily    = 20
+	sizeofInetDiagReqV2 = 56
+	sizeofInetDiagMsg   = 72
+)
+
+var nativeEndian binary.ByteOrder = binary.LittleEndian
+
+func init() {
+	var x uint16 = 1
+	if *(*byte)(unsafe.Pointer(&x)) == 0 {
+		nativeEndian = binary.BigEndian
+	}
+}
+
+// ListSockets lists the sockets of a family (AF_INET, AF_INET6) and protocol
+// (IPPROTO_TCP, IPPROTO_UDP) by NETLINK_SOCK_DIAG, and falls back to
+// /proc/net/{tcp,tcp6,udp,udp6} if netlink is not available.
+func ListSockets(family, proto uint8) ([]*Socket, error) {
+	sockets, err := sockDiag(family, proto)
+	if err == nil {
+		return sockets, nil
+	}
+
+	path, ok := procNetPath(family, proto)
+	if !ok {
+		return nil, err
+	}
+	return procNetSockets(path, family, proto)
+}
+
+func sockDiag(family, proto uint8) ([]*Socket, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_INET_DIAG)
+	if err != nil {
+		return nil, err
+	}
+	defer syscall.Close(fd)
+
+	req := make([]byte, syscall.NLMSG_HDRLEN+sizeofInetDiagReqV2)
+	nativeEndian.PutUint32(req[0:4], uint32(len(req)))
+	nativeEndian.PutUint16(req[4:6], sockDiagByFamily)
+	nativeEndian.PutUint16(req[6:8], syscall.NLM_F_REQUEST|syscall.NLM_F_DUMP)
+	nativeEndian.PutUint32(req[8:12], 1)
+
+	// struct inet_diag_req_v2, all states
+	body := req[syscall.NLMSG_HDRLEN:]
+	body[0] = family
+	body[1] = proto
+	nativeEndian.PutUint32(body[4:8], 0xffffffff)
+
+	if err = syscall.Sendto(fd, req, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return nil, err
+	}
+
+	var ret []*Socket
+	buf := make([]byte, 64*1024)
+	for {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			return nil, err
+		}
+
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return nil, err
+		}
+
+		for _, m := range msgs {
+			switch m.Header.Type {
+			case syscall.NLMSG_DONE:
+				return ret, nil
+			case syscall.NLMSG_ERROR:
+				if len(m.Data) >= 4 {
+					if errno := int32(nativeEndian.Uint32(m.Data[0:4])); errno != 0 {
+						return nil, syscall.Errno(-errno)
+					}
+				}
+				return nil, fmt.Errorf("sock_diag: netlink error")
+			}
+
+			if len(m.Data) < sizeofInetDiagMsg {
+				continue
+			}
+			ret = append(ret, parseInetDiagMsg(m.Data, proto))
+		}
+	}
+}
+
+// parseInetDiagMsg parses struct inet_diag_msg
+func parseInetDiagMsg(b []byte, proto uint8) *Socket {
+	s := &Socket{
+		Family:     b[0],
+		Proto:      proto,
+		State:      b[1],
+		LocalPort:  binary.BigEndian.Uint16(b[4:6]),
+		RemotePort: binary.BigEndian.Uint16(b[6:8]),
+		RQueue:     nativeEndian.Uint32(b[56:60]),
+		WQueue:     nativeEndian.Uint32(b[60:64]),
+		Uid:        nativeEndian.Uint32(b[64:68]),
+		Inode:      nativeEndian.Uint32(b[68:72]),
+	}
+
+	if s.Family == syscall.AF_INET {
+		s.LocalIP = net.IP(append([]byte(nil), b[8:12]...))
+		s.RemoteIP = net.IP(append([]byte(nil), b[24:28]...))
+	} else {
+		s.LocalIP = net.IP(append([]byte(nil), b[8:24]...))
+		s.RemoteIP = net.IP(append([]byte(nil), b[24:40]...))
+	}
+	return s
+}
+
+func procNetPath(family, proto uint8) (string, bool) {
+	var name string
+	switch proto {
+	case syscall.IPPROTO_TCP:
+		name = "tcp"
+	case syscall.IPPROTO_UDP:
+		name = "udp"
+	default:
+		return "", false
+	}
+
+	if family == syscall.AF_INET6 {
+		name += "6"
+	}
+	return "/proc/net/" + name, true
+}
+
+// procNetSockets parses /proc/net/tcp and friends:
+// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode
+func procNetSockets(path string, family, proto uint8) ([]*Socket, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var ret []*Socket
+	scanner := bufio.NewScanner(f)
+	// skip header
+	scanner.Scan()
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+
+		s := &Socket{Family: family, Proto: proto, FromProc: true}
+		if s.LocalIP, s.LocalPort, err = parseProcNetAddr(fields[1]); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if s.RemoteIP, s.RemotePort, err = parseProcNetAddr(fields[2]); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+
+		state, _ := strconv.ParseUint(fields[3], 16, 8)
+		s.State = uint8(state)
+
+		if queues := strings.Split(fields[4], ":"); len(queues) == 2 {
+			tx, _ := strconv.ParseUint(queues[0], 16, 32)
+			rx, _ := strconv.ParseUint(queues[1], 16, 32)
+			s.WQueue, s.RQueue = uint32(tx), uint32(rx)
+		}
+		if s.State == TCP_LISTEN {
+			// tx_queue of a listener is not its backlog
+			s.WQueue = 0
+		}
+
+		uid, _ := strconv.ParseUint(fields[7], 10, 32)
+		inode, _ := strconv.ParseUint(fields[9], 10, 32)
+		s.Uid, s.Inode = uint32(uid), uint32(inode)
+
+		ret = append(ret, s)
+	}
+
+	return ret, scanner.Err()
+}
+
+// parseProcNetAddr parses 0100007F:0277, the address is in words of host
+// byte order and the port in network byte order
+func parseProcNetAddr(s string) (net.IP, uint16, error) {
+	arr := strings.Split(s, ":")
+	if len(arr) != 2 {
+		return nil, 0, fmt.Errorf("bad address %s", s)
+	}
+
+	b, err := hex.DecodeString(arr[0])
+	if err != nil || (len(b) != 4 && len(b) != 16) {
+		return nil, 0, fmt.Errorf("bad address %s", s)
+	}
+
+	ip := make(net.IP, len(b))
+	for i := 0; i < len(b); i += 4 {
+		nativeEndian.PutUint32(ip[i:i+4], binary.BigEndian.Uint32(b[i:i+4]))
+	}
+
+	port, err := strconv.ParseUint(arr[1], 16, 16)
+	if err != nil {
+		return nil, 0, fmt.Errorf("bad port %s", s)
+	}
+
+	return ip, uint16(port), nil
+}
@@ -1,20 +1,129 @@
 package funcs
 
 import (
+	"bufio"
+	"fmt"
 	"github.com/open-falcon/common/model"
-	"github.com/toolkits/nux"
 	"log"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
 )
 
+// ReadSockstat parses /proc/net/sockstat and /proc/net/sockstat6, e.g.
+// "TCP: inuse 4 orphan 0 tw 0 alloc 4 mem 0" => {TCP => {inuse => 4, ...}}
+func ReadSockstat(path string) (map[string]map[string]uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ret := make(map[string]map[string]uint64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+
+		m := make(map[string]uint64)
+		for i := 1; i+1 < len(fields); i += 2 {
+			m[fields[i]], _ = strconv.ParseUint(fields[i+1], 10, 64)
+		}
+		ret[strings.TrimSuffix(fields[0], ":")] = m
+	}
+
+	return ret, scanner.Err()
+}
+
+// slabTimewait is the active tw_sock_TCP objects in /proc/slabinfo
+func slabTimewait() (uint64, bool) {
+	f, err := os.Open("/proc/slabinfo")
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 1 && fields[0] == "tw_sock_TCP" {
+			v, err := strconv.ParseUint(fields[1], 10, 64)
+			return v, err == nil
+		}
+	}
+	return 0, false
+}
+
+// TcpStateCounts counts the tcp sockets of a family by state
+func TcpStateCounts(family uint8) (map[uint8]uint64, error) {
+	sockets, err := ListSockets(family, syscall.IPPROTO_TCP)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make(map[uint8]uint64)
+	for _, s := range sockets {
+		state := s.State
+		if state == TCP_NEW_SYN_RECV {
+			state = TCP_SYN_RECV
+		}
+		ret[state]++
+	}
+	return ret, nil
+}
+
+// SocketStatSummaryMetrics reports what `ss -s` did, without running it,
+// plus the tcp sockets of every state for ipv4 and ipv6.
 func SocketStatSummaryMetrics() (L []*model.MetricValue) {
-	ssMap, err := nux.SocketStatSummary()
+	var estab, synrecv uint64
+	for _, family := range []uint8{syscall.AF_INET, syscall.AF_INET6} {
+		familyTag := "family=ipv4"
+		if family == syscall.AF_INET6 {
+			familyTag = "family=ipv6"
+		}
+
+		counts, err := TcpStateCounts(family)
+		if err != nil {
+			log.Println("list tcp sockets fail", familyTag, err)
+			continue
+		}
+
+		for state, name := range TcpStates {
+			tags := fmt.Sprintf("%s,state=%s", familyTag, name)
+			L = append(L, GaugeValue("ss.tcp.state", counts[state], tags))
+		}
+
+		estab += counts[TCP_ESTABLISHED]
+		synrecv += counts[TCP_SYN_RECV]
+	}
+
+	sockstat, err := ReadSockstat("/proc/net/sockstat")
 	if err != nil {
 		log.Println(err)
 		return
 	}
 
-	for k, v := range ssMap {
-		L = append(L, GaugeValue("ss."+k, v))
+	var inuse6 uint64
+	if sockstat6, err := ReadSockstat("/proc/net/sockstat6"); err == nil {
+		inuse6 = sockstat6["TCP6"]["inuse"]
+	}
+
+	tcp := sockstat["TCP"]
+	closed := int64(tcp["alloc"]) - (int64(tcp["inuse"]) + int64(inuse6) - int64(tcp["tw"]))
+	if closed < 0 {
+		closed = 0
+	}
+
+	L = append(L, GaugeValue("ss.estab", estab))
+	L = append(L, GaugeValue("ss.closed", closed))
+	L = append(L, GaugeValue("ss.orphaned", tcp["orphan"]))
+	L = append(L, GaugeValue("ss.synrecv", synrecv))
+	L = append(L, GaugeValue("ss.timewait", tcp["tw"]))
+	if tw, ok := slabTimewait(); ok {
+		L = append(L, GaugeValue("ss.slabinfo.timewait", tw))
 	}
 
 	return
@@ -0,0 +1,85 @@
+package funcs
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/open-falcon/common/model"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type SoftnetStat struct {
+	Cpu         int
+	Processed   uint64
+	Dropped     uint64
+	TimeSqueeze uint64
+}
+
+var softnetImbalance = newImbalanceTracker()
+
+// ReadSoftnetStat parses /proc/net/softnet_stat, one line of hex values per
+// cpu. Since linux 5.10 the 13th column is the cpu id, before that the line
+// number is.
+func ReadSoftnetStat() ([]*SoftnetStat, error) {
+	f, err := os.Open("/proc/net/softnet_stat")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var ret []*SoftnetStat
+	scanner := bufio.NewScanner(f)
+	for idx := 0; scanner.Scan(); idx++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+
+		vals := make([]uint64, len(fields))
+		for i, field := range fields {
+			vals[i], _ = strconv.ParseUint(field, 16, 64)
+		}
+
+		st := &SoftnetStat{Cpu: idx, Processed: vals[0], Dropped: vals[1], TimeSqueeze: vals[2]}
+		if len(vals) >= 13 {
+			st.Cpu = int(vals[12])
+		}
+		ret = append(ret, st)
+	}
+
+	return ret, scanner.Err()
+}
+
+func SoftnetMetrics() (L []*model.MetricValue) {
+	stats, err := ReadSoftnetStat()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	var processed, dropped, timeSqueeze uint64
+	perCpu := make([]uint64, len(stats))
+	for i, st := range stats {
+		tags := fmt.Sprintf("cpu=%d", st.Cpu)
+		L = append(L, CounterValue("net.softnet.processed", st.Processed, tags))
+		L = append(L, CounterValue("net.softnet.dropped", st.Dropped, tags))
+		L = append(L, CounterValue("net.softnet.time_squeeze", st.TimeSqueeze, tags))
+
+		processed += st.Processed
+		dropped += st.Dropped
+		timeSqueeze += st.TimeSqueeze
+		perCpu[i] = st.Processed
+	}
+
+	L = append(L, CounterValue("net.softnet.processed", processed))
+	L = append(L, CounterValue("net.softnet.dropped", dropped))
+	L = append(L, CounterValue("net.softnet.time_squeeze", timeSqueeze))
+
+	if imbalance, ok := softnetImbalance.Update("processed", perCpu); ok {
+		L = append(L, GaugeValue("net.softnet.imbalance", imbalance))
+	}
+
+	return
+}
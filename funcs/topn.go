@@ -0,0 +1,140 @@
+package funcs
+
+import (
+	"fmt"
+	"github.com/open-falcon/agent/g"
+	"github.com/open-falcon/common/model"
+	"log"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+// TopProc is a process in a ranking
+type TopProc struct {
+	Pid   int     `json:"pid"`
+	Name  string  `json:"name"`
+	Value float64 `json:"value"`
+}
+
+type procRanking struct {
+	Name   string
+	Metric string
+	Value  func(*ProcUsage) float64
+}
+
+var procRankings = []procRanking{
+	{"cpu", "proc.top.cpu.percent", func(u *ProcUsage) float64 { return u.CpuPercent }},
+	{"mem", "proc.top.mem.rss", func(u *ProcUsage) float64 { return float64(u.Rss) }},
+	{"swap", "proc.top.mem.swap", func(u *ProcUsage) float64 { return float64(u.Swap) }},
+	{"fd", "proc.top.fd.count", func(u *ProcUsage) float64 { return float64(u.Fds) }},
+	{"io", "proc.top.io.bytes", func(u *ProcUsage) float64 { return u.IORate }},
+}
+
+// the longest ranking kept for the http api
+const TopProcsMax = 100
+
+var (
+	// of the last round of the collector
+	lastTopProcs     map[string][]*TopProc
+	lastTopProcsLock = new(sync.RWMutex)
+
+	unsafeTagChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+)
+
+// SanitizeTagValue makes s safe to use as a tag value
+func SanitizeTagValue(s string) string {
+	return unsafeTagChars.ReplaceAllString(s, "_")
+}
+
+// TopProcs ranks all processes by every resource, keeping the top n of each.
+// Processes not using a resource at all are left out of its ranking.
+func TopProcs(round *ProcRound, n int) (map[string][]*TopProc, error) {
+	ps, err := round.Procs()
+	if err != nil {
+		return nil, err
+	}
+
+	usages := make([]*ProcUsage, 0, len(ps))
+	for _, p := range ps {
+		u, err := round.Usage(p.Pid)
+		if err != nil {
+			// exited
+			continue
+		}
+		usages = append(usages, u)
+	}
+
+	if n > len(usages) {
+		n = len(usages)
+	}
+
+	ret := make(map[string][]*TopProc, len(procRankings))
+	for _, r := range procRankings {
+		value := r.Value
+		sort.SliceStable(usages, func(i, j int) bool { return value(usages[i]) > value(usages[j]) })
+
+		top := make([]*TopProc, 0, n)
+		for i := 0; i < len(usages) && i < n; i++ {
+			v := value(usages[i])
+			if v <= 0 {
+				break
+			}
+			top = append(top, &TopProc{Pid: usages[i].Pid, Name: SanitizeTagValue(usages[i].Comm), Value: v})
+		}
+		ret[r.Name] = top
+	}
+
+	return ret, nil
+}
+
+// TopProcMetrics reports the top collector.topN processes by cpu, memory,
+// swap, fds and disk io, tagged by name and pid
+func TopProcMetrics(round *ProcRound) (L []*model.MetricValue) {
+	c := g.Config().Collector
+	if c == nil || c.TopN <= 0 {
+		return
+	}
+
+	n := c.TopN
+	if n < TopProcsMax {
+		n = TopProcsMax
+	}
+	rankings, err := TopProcs(round, n)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	lastTopProcsLock.Lock()
+	lastTopProcs = rankings
+	lastTopProcsLock.Unlock()
+
+	for _, r := range procRankings {
+		for i, p := range rankings[r.Name] {
+			if i >= c.TopN {
+				break
+			}
+			L = append(L, GaugeValue(r.Metric, p.Value, fmt.Sprintf("name=%s,pid=%d", p.Name, p.Pid)))
+		}
+	}
+
+	return
+}
+
+// LastTopProcs is the top n of the rankings of the last round of the
+// collector, n at most TopProcsMax, empty if collector.topN is 0
+func LastTopProcs(n int) map[string][]*TopProc {
+	lastTopProcsLock.RLock()
+	defer lastTopProcsLock.RUnlock()
+
+	ret := make(map[string][]*TopProc, len(procRankings))
+	for _, r := range procRankings {
+		top := lastTopProcs[r.Name]
+		if len(top) > n {
+			top = top[:n]
+		}
+		ret[r.Name] = top
+	}
+	return ret
+}
@@ -1,21 +1,62 @@
 package funcs
 
 import (
-	"bufio"
-	"bytes"
+	"crypto/tls"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
-	"strings"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
 
 	"github.com/open-falcon/agent/g"
 	"github.com/open-falcon/common/model"
-	"github.com/toolkits/file"
-	"github.com/toolkits/sys"
 )
 
+// at most so many urls are probed at the same time
+const urlProbeWorkers = 8
+
+// at most so much of the body is read, for the size and the body regex
+const urlMaxBody = 1 << 20
+
+// UrlProbe is the result of probing a url, the durations are zero if the
+// phase did not happen, e.g. no dns for an ip or no tls for http
+type UrlProbe struct {
+	Ok        bool
+	Status    int
+	Dns       time.Duration
+	Connect   time.Duration
+	Tls       time.Duration
+	FirstByte time.Duration
+	Total     time.Duration
+	Size      int64
+}
+
+// AllUrlChecks are the url.check.health of collector.urls and of hbs, hbs
+// wins if both have the same tags
+func AllUrlChecks() []*g.UrlCheck {
+	local := g.LocalUrls()
+	remote := g.ReportUrls()
+
+	seen := make(map[string]bool, len(remote))
+	ret := make([]*g.UrlCheck, 0, len(local)+len(remote))
+	for _, c := range remote {
+		seen[c.Tags()] = true
+		ret = append(ret, c)
+	}
+	for _, c := range local {
+		if !seen[c.Tags()] {
+			ret = append(ret, c)
+		}
+	}
+	return ret
+}
+
 func UrlMetrics() (L []*model.MetricValue) {
-	reportUrls := g.ReportUrls()
-	sz := len(reportUrls)
+	checks := AllUrlChecks()
+	sz := len(checks)
 	if sz == 0 {
 		return
 	}
@@ -23,32 +64,139 @@ func UrlMetrics() (L []*model.MetricValue) {
 	if err != nil {
 		hostname = "None"
 	}
-	for furl, timeout := range reportUrls {
-		tags := fmt.Sprintf("url=%v,timeout=%v,src=%v", furl, timeout, hostname)
-		if ok, _ := probeUrl(furl, timeout); !ok {
+
+	probes := make([]*UrlProbe, sz)
+	runParallel(sz, urlProbeWorkers, func(i int) {
+		probes[i] = probeUrl(checks[i])
+	})
+
+	for i, c := range checks {
+		p := probes[i]
+		tags := fmt.Sprintf("%s,src=%v", c.Tags(), hostname)
+		if !p.Ok {
 			L = append(L, GaugeValue(g.URL_CHECK_HEALTH, 0, tags))
+		} else {
+			L = append(L, GaugeValue(g.URL_CHECK_HEALTH, 1, tags))
+		}
+
+		L = append(L, GaugeValue("url.check.status", p.Status, tags))
+		if p.Status == 0 {
 			continue
 		}
-		L = append(L, GaugeValue(g.URL_CHECK_HEALTH, 1, tags))
+
+		L = append(L, GaugeValue("url.check.time.dns", durationMs(p.Dns), tags))
+		L = append(L, GaugeValue("url.check.time.connect", durationMs(p.Connect), tags))
+		L = append(L, GaugeValue("url.check.time.tls", durationMs(p.Tls), tags))
+		L = append(L, GaugeValue("url.check.time.first_byte", durationMs(p.FirstByte), tags))
+		L = append(L, GaugeValue("url.check.time.total", durationMs(p.Total), tags))
+		L = append(L, GaugeValue("url.check.size", p.Size, tags))
 	}
 	return
 }
 
-func probeUrl(furl string, timeout string) (bool, error) {
-	bs, err := sys.CmdOutBytes("curl", "--max-filesize", "102400", "-I", "-m", timeout, "-o", "/dev/null", "-s", "-w", "%{http_code}", furl)
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// probeUrl sends one request on a new connection, so that every probe
+// measures dns and connecting too. Redirects are not followed, a 301 is
+// what the url returns.
+func probeUrl(c *g.UrlCheck) *UrlProbe {
+	p := &UrlProbe{}
+
+	req, err := http.NewRequest(c.RequestMethod(), c.Url, nil)
 	if err != nil {
-		log.Printf("probe url [%v] failed.the err is: [%v]\n", furl, err)
-		return false, err
+		log.Printf("probe url [%v] failed.the err is: [%v]\n", c.Url, err)
+		return p
 	}
-	reader := bufio.NewReader(bytes.NewBuffer(bs))
-	retcode, err := file.ReadLine(reader)
+	for k, v := range c.Headers {
+		if k == "Host" {
+			req.Host = v
+			continue
+		}
+		req.Header.Set(k, v)
+	}
+
+	// dialing ipv4 and ipv6 at the same time connects in parallel, only the
+	// first connection made is measured
+	var dnsStart, tlsStart time.Time
+	connectStarts := make(map[string]time.Time)
+	connectLock := new(sync.Mutex)
+	done := false
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			p.Dns = time.Since(dnsStart)
+		},
+		ConnectStart: func(network, addr string) {
+			connectLock.Lock()
+			connectStarts[addr] = time.Now()
+			connectLock.Unlock()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			connectLock.Lock()
+			defer connectLock.Unlock()
+			if err == nil && !done && p.Connect == 0 {
+				p.Connect = time.Since(connectStarts[addr])
+			}
+		},
+		TLSHandshakeStart: func() { tlsStart = time.Now() },
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			p.Tls = time.Since(tlsStart)
+		},
+	}
+
+	client := &http.Client{
+		Timeout: time.Duration(c.Timeout) * time.Second,
+		Transport: &http.Transport{
+			Proxy:             http.ProxyFromEnvironment,
+			DisableKeepAlives: true,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+	// a dial which lost the race may still be reported after
+	connectLock.Lock()
+	done = true
+	connectLock.Unlock()
 	if err != nil {
-		log.Println("read retcode failed.err is:", err)
-		return false, err
+		log.Printf("probe url [%v] failed.the err is: [%v]\n", c.Url, err)
+		return p
 	}
-	if strings.TrimSpace(string(retcode)) != "200" {
-		log.Printf("return code [%v] is not 200.query url is [%v]", string(retcode), furl)
-		return false, err
+	defer resp.Body.Close()
+
+	p.FirstByte = time.Since(start)
+	p.Status = resp.StatusCode
+
+	var body []byte
+	if c.BodyRegex != nil {
+		body, err = ioutil.ReadAll(io.LimitReader(resp.Body, urlMaxBody))
+		p.Size = int64(len(body))
+	} else {
+		p.Size, err = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, urlMaxBody))
+	}
+	p.Total = time.Since(start)
+	if err != nil {
+		log.Printf("read body of url [%v] failed.the err is: [%v]\n", c.Url, err)
+		return p
+	}
+	if resp.ContentLength > p.Size {
+		p.Size = resp.ContentLength
 	}
-	return true, err
+
+	if !c.Accept(p.Status) {
+		log.Printf("return code [%v] is not accepted.query url is [%v]", p.Status, c.Url)
+		return p
+	}
+	if c.BodyRegex != nil && !c.BodyRegex.Match(body) {
+		log.Printf("body does not match [%v].query url is [%v]", c.Body, c.Url)
+		return p
+	}
+
+	p.Ok = true
+	return p
 }
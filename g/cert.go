@@ -0,0 +1,78 @@
+package g
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// CertCheck is a cert.expire.days, the certificates are those a server
+// presents, e.g. 'addr=example.com:443', 'addr=10.0.0.1:443,sni=example.com',
+// or those in a pem file, e.g. 'file=/etc/nginx/cert.pem,sni=example.com'.
+// The hostname is verified against sni, or the host of addr if not given.
+type CertCheck struct {
+	Tags       string
+	Addr       string
+	File       string
+	ServerName string
+	// in seconds
+	Timeout int
+}
+
+// ParseCertCheck parses the tags of cert.expire.days
+func ParseCertCheck(tags string) (*CertCheck, error) {
+	m, err := ParseTags(tags, "addr", "file", "sni", "timeout")
+	if err != nil {
+		return nil, err
+	}
+
+	c := &CertCheck{Tags: tags, Addr: m["addr"], File: m["file"], ServerName: m["sni"]}
+	if (c.Addr == "") == (c.File == "") {
+		return nil, fmt.Errorf("exactly one of addr and file is needed")
+	}
+
+	if c.Addr != "" {
+		host, err := checkAddr(c.Addr)
+		if err != nil {
+			return nil, err
+		}
+		if c.ServerName == "" {
+			c.ServerName = host
+		}
+	}
+
+	if c.File != "" && !filepath.IsAbs(c.File) {
+		return nil, fmt.Errorf("file %s is not an absolute path", c.File)
+	}
+
+	if c.Timeout, err = parseTimeout(m, 5); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// ParseCertChecks parses a list of cert.expire.days tags, returning the
+// errors of the invalid ones by tags
+func ParseCertChecks(list []string) (map[string]*CertCheck, map[string]string) {
+	certs := make(map[string]*CertCheck)
+	errs := make(map[string]string)
+	for _, tags := range list {
+		c, err := ParseCertCheck(tags)
+		if err != nil {
+			errs[tags] = err.Error()
+			continue
+		}
+		certs[tags] = c
+	}
+	return certs, errs
+}
+
+var localCerts = newLocalChecks("collector.certs", func(c *CollectorConfig) (interface{}, map[string]string) {
+	return ParseCertChecks(c.Certs)
+})
+
+// LocalCerts are the cert.expire.days of collector.certs
+func LocalCerts() map[string]*CertCheck {
+	v, _ := localCerts.get()
+	return v.(map[string]*CertCheck)
+}
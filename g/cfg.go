@@ -37,7 +37,38 @@ type HttpConfig struct {
 }
 
 type CollectorConfig struct {
-	IfacePrefix []string `json:"ifacePrefix"`
+	IfacePrefix   []string            `json:"ifacePrefix"`
+	IfaceInclude  string              `json:"ifaceInclude"`
+	IfaceExclude  *string             `json:"ifaceExclude"`
+	DiskInclude   string              `json:"diskInclude"`
+	DiskExclude   *string             `json:"diskExclude"`
+	DiskStableId  bool                `json:"diskStableId"`
+	DiskUtilPeak  bool                `json:"diskUtilPeak"`
+	FsInclude     string              `json:"fsInclude"`
+	FsExclude     *string             `json:"fsExclude"`
+	MountInclude  string              `json:"mountInclude"`
+	MountExclude  *string             `json:"mountExclude"`
+	MountTimeout  int                 `json:"mountTimeout"`
+	DfTrendFile   string              `json:"dfTrendFile"`
+	DfTrendHours  int                 `json:"dfTrendHours"`
+	Snmp          map[string][]string `json:"snmp"`
+	Procs         []string            `json:"procs"`
+	TopN          int                 `json:"topN"`
+	Supervisors   []string            `json:"supervisors"`
+	Urls          []*UrlCheck         `json:"urls"`
+	Certs         []string            `json:"certs"`
+	TcpConnects   []string            `json:"tcpConnects"`
+	DnsResolves   []string            `json:"dnsResolves"`
+	Pings         []string            `json:"pings"`
+	DuTimeout     int                 `json:"duTimeout"`
+	DuRefresh     int                 `json:"duRefresh"`
+	DuRate        int                 `json:"duRate"`
+	Files         []string            `json:"files"`
+	Logs          []*LogConfig        `json:"logs"`
+	LogStateFile  string              `json:"logStateFile"`
+	KmsgPatterns  map[string]string   `json:"kmsgPatterns"`
+	KmsgEventUrl  string              `json:"kmsgEventUrl"`
+	KmsgStateFile string              `json:"kmsgStateFile"`
 }
 
 type GlobalConfig struct {
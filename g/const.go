@@ -17,4 +17,9 @@ const (
 	NET_PORT_LISTEN  = "net.port.listen"
 	DU_BS            = "du.bs"
 	PROC_NUM         = "proc.num"
+	CERT_EXPIRE_DAYS = "cert.expire.days"
+	NET_TCP_CONNECT  = "net.tcp.connect"
+	DNS_RESOLVE      = "dns.resolve"
+	NET_PING_LOSS    = "net.ping.loss"
+	FILE_EXISTS      = "file.exists"
 )
@@ -0,0 +1,64 @@
+package g
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+)
+
+// FileCheck is a file.exists, e.g. 'path=/data/export/*.csv,lines=true'.
+// path may be a glob, lines counts the lines of the files too.
+type FileCheck struct {
+	Tags  string
+	Path  string
+	Lines bool
+}
+
+// ParseFileCheck parses the tags of file.exists
+func ParseFileCheck(tags string) (*FileCheck, error) {
+	m, err := ParseTags(tags, "path", "lines")
+	if err != nil {
+		return nil, err
+	}
+
+	c := &FileCheck{Tags: tags, Path: m["path"]}
+	if !filepath.IsAbs(c.Path) {
+		return nil, fmt.Errorf("path %s is not an absolute path", c.Path)
+	}
+	if _, err := filepath.Match(c.Path, ""); err != nil {
+		return nil, fmt.Errorf("bad path %s: %v", c.Path, err)
+	}
+
+	if val, ok := m["lines"]; ok {
+		if c.Lines, err = strconv.ParseBool(val); err != nil {
+			return nil, fmt.Errorf("bad lines %s", val)
+		}
+	}
+	return c, nil
+}
+
+// ParseFileChecks parses a list of file.exists tags, returning the errors
+// of the invalid ones by tags
+func ParseFileChecks(list []string) (map[string]*FileCheck, map[string]string) {
+	checks := make(map[string]*FileCheck)
+	errs := make(map[string]string)
+	for _, tags := range list {
+		c, err := ParseFileCheck(tags)
+		if err != nil {
+			errs[tags] = err.Error()
+			continue
+		}
+		checks[tags] = c
+	}
+	return checks, errs
+}
+
+var localFiles = newLocalChecks("collector.files", func(c *CollectorConfig) (interface{}, map[string]string) {
+	return ParseFileChecks(c.Files)
+})
+
+// LocalFiles are the file.exists of collector.files
+func LocalFiles() map[string]*FileCheck {
+	v, _ := localFiles.get()
+	return v.(map[string]*FileCheck)
+}
@@ -0,0 +1,81 @@
+package g
+
+import (
+	"log"
+	"sort"
+	"sync"
+)
+
+// localChecks is what is parsed of a list of the collector configuration,
+// e.g. collector.pings, parsed again only after the configuration is
+// reloaded
+type localChecks struct {
+	sync.Mutex
+	name   string
+	parse  func(*CollectorConfig) (interface{}, map[string]string)
+	config *GlobalConfig
+	value  interface{}
+	// of the invalid entries, by entry
+	errs map[string]string
+}
+
+var (
+	localChecksList []*localChecks
+	localChecksLock = new(sync.Mutex)
+)
+
+// newLocalChecks registers a list of the collector configuration, whose
+// invalid entries are logged and listed by LocalErrors
+func newLocalChecks(name string, parse func(*CollectorConfig) (interface{}, map[string]string)) *localChecks {
+	l := &localChecks{name: name, parse: parse}
+
+	localChecksLock.Lock()
+	defer localChecksLock.Unlock()
+	localChecksList = append(localChecksList, l)
+	return l
+}
+
+func (this *localChecks) get() (interface{}, map[string]string) {
+	this.Lock()
+	defer this.Unlock()
+
+	c := Config()
+	if c == this.config {
+		return this.value, this.errs
+	}
+
+	collector := c.Collector
+	if collector == nil {
+		collector = &CollectorConfig{}
+	}
+
+	this.value, this.errs = this.parse(collector)
+
+	keys := make([]string, 0, len(this.errs))
+	for key := range this.errs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		log.Println("invalid", this.name, key, ":", this.errs[key])
+	}
+
+	this.config = c
+	return this.value, this.errs
+}
+
+// LocalErrors are the invalid entries of the collector configuration, by
+// name of the list, e.g. collector.pings, and entry
+func LocalErrors() map[string]map[string]string {
+	localChecksLock.Lock()
+	list := localChecksList
+	localChecksLock.Unlock()
+
+	ret := make(map[string]map[string]string)
+	for _, l := range list {
+		if _, errs := l.get(); len(errs) > 0 {
+			ret[l.name] = errs
+		}
+	}
+	return ret
+}
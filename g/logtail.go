@@ -0,0 +1,124 @@
+package g
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// DefaultLogMaxSeries is the number of tag combinations of a LogRule if
+// maxSeries is not given
+const DefaultLogMaxSeries = 100
+
+// LogConfig is a log file of collector.logs and the rules applied to every
+// line appended to it
+type LogConfig struct {
+	Path  string     `json:"path"`
+	Rules []*LogRule `json:"rules"`
+}
+
+// LogRule turns the lines matching Regex into a metric. A counter counts
+// the lines, or adds up the named group value if there is one, a gauge is
+// the last value, and a histogram counts the values in Buckets, as
+// metric.bucket with tag le, metric.sum and metric.count. The other named
+// groups are tags besides Tags, of at most MaxSeries combinations.
+type LogRule struct {
+	Metric    string    `json:"metric"`
+	Type      string    `json:"type"`
+	Regex     string    `json:"regex"`
+	Tags      string    `json:"tags"`
+	Buckets   []float64 `json:"buckets"`
+	MaxSeries int       `json:"maxSeries"`
+
+	Re     *regexp.Regexp    `json:"-"`
+	TagMap map[string]string `json:"-"`
+}
+
+// Validate checks the fields and compiles the regex
+func (this *LogRule) Validate() error {
+	if this.Metric == "" {
+		return fmt.Errorf("blank metric")
+	}
+
+	re, err := regexp.Compile(this.Regex)
+	if err != nil {
+		return fmt.Errorf("bad regex of %s: %v", this.Metric, err)
+	}
+	this.Re = re
+
+	this.TagMap = make(map[string]string)
+	if this.Tags != "" {
+		if this.TagMap, err = SplitTags(this.Tags); err != nil {
+			return fmt.Errorf("bad tags of %s: %v", this.Metric, err)
+		}
+	}
+
+	if this.MaxSeries < 0 {
+		return fmt.Errorf("bad maxSeries %d of %s", this.MaxSeries, this.Metric)
+	}
+	if this.MaxSeries == 0 {
+		this.MaxSeries = DefaultLogMaxSeries
+	}
+
+	hasValue := false
+	for _, name := range re.SubexpNames() {
+		if name == "value" {
+			hasValue = true
+		}
+	}
+
+	switch this.Type {
+	case "", "counter":
+		this.Type = "counter"
+	case "gauge":
+		if !hasValue {
+			return fmt.Errorf("gauge %s has no named group value", this.Metric)
+		}
+	case "histogram":
+		if !hasValue {
+			return fmt.Errorf("histogram %s has no named group value", this.Metric)
+		}
+		if len(this.Buckets) == 0 || !sort.Float64sAreSorted(this.Buckets) {
+			return fmt.Errorf("buckets of histogram %s are not in ascending order", this.Metric)
+		}
+	default:
+		return fmt.Errorf("unknown type %s of %s", this.Type, this.Metric)
+	}
+
+	return nil
+}
+
+var localLogs = newLocalChecks("collector.logs", func(c *CollectorConfig) (interface{}, map[string]string) {
+	logs := []*LogConfig{}
+	errs := make(map[string]string)
+	for _, lc := range c.Logs {
+		if !filepath.IsAbs(lc.Path) {
+			errs[lc.Path] = "not an absolute path"
+			continue
+		}
+
+		rules := []*LogRule{}
+		ruleErrs := []string{}
+		for _, rule := range lc.Rules {
+			if err := rule.Validate(); err != nil {
+				ruleErrs = append(ruleErrs, err.Error())
+				continue
+			}
+			rules = append(rules, rule)
+		}
+		if len(ruleErrs) > 0 {
+			errs[lc.Path] = strings.Join(ruleErrs, "; ")
+		}
+		logs = append(logs, &LogConfig{Path: lc.Path, Rules: rules})
+	}
+	return logs, errs
+})
+
+// LocalLogs are the valid logs of collector.logs, an invalid rule is left
+// out
+func LocalLogs() []*LogConfig {
+	v, _ := localLogs.get()
+	return v.([]*LogConfig)
+}
@@ -0,0 +1,138 @@
+package g
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// TcpCheck is a net.tcp.connect, e.g. 'addr=db.example.com:3306,timeout=3'
+type TcpCheck struct {
+	Tags string
+	Addr string
+	// in seconds
+	Timeout int
+}
+
+// ParseTcpCheck parses the tags of net.tcp.connect
+func ParseTcpCheck(tags string) (*TcpCheck, error) {
+	m, err := ParseTags(tags, "addr", "timeout")
+	if err != nil {
+		return nil, err
+	}
+
+	c := &TcpCheck{Tags: tags, Addr: m["addr"]}
+	if c.Addr == "" {
+		return nil, fmt.Errorf("addr is needed")
+	}
+	if _, err := checkAddr(c.Addr); err != nil {
+		return nil, err
+	}
+
+	if c.Timeout, err = parseTimeout(m, 3); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// record types a DnsCheck can look up
+var dnsTypes = map[string]bool{"A": true, "AAAA": true, "CNAME": true, "MX": true, "NS": true, "TXT": true, "SRV": true, "PTR": true}
+
+// DnsCheck is a dns.resolve, e.g. 'name=example.com,type=A,server=10.0.0.2:53,
+// expect=93.184.216.34'. type is A by default, the system resolver is used
+// if server is not given, and expect is an answer that must be found.
+type DnsCheck struct {
+	Tags   string
+	Name   string
+	Type   string
+	Server string
+	Expect string
+	// in seconds
+	Timeout int
+}
+
+// ParseDnsCheck parses the tags of dns.resolve
+func ParseDnsCheck(tags string) (*DnsCheck, error) {
+	m, err := ParseTags(tags, "name", "type", "server", "expect", "timeout")
+	if err != nil {
+		return nil, err
+	}
+
+	c := &DnsCheck{Tags: tags, Name: m["name"], Type: strings.ToUpper(m["type"]), Server: m["server"], Expect: m["expect"]}
+	if c.Name == "" {
+		return nil, fmt.Errorf("name is needed")
+	}
+
+	if c.Type == "" {
+		c.Type = "A"
+	}
+	if !dnsTypes[c.Type] {
+		return nil, fmt.Errorf("unsupported type %s", c.Type)
+	}
+	if c.Type == "PTR" && net.ParseIP(c.Name) == nil {
+		return nil, fmt.Errorf("name of PTR must be an ip")
+	}
+
+	if c.Server != "" {
+		if net.ParseIP(c.Server) != nil {
+			c.Server = net.JoinHostPort(c.Server, "53")
+		}
+		if _, err := checkAddr(c.Server); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.Timeout, err = parseTimeout(m, 3); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ParseTcpChecks parses a list of net.tcp.connect tags, returning the
+// errors of the invalid ones by tags
+func ParseTcpChecks(list []string) (map[string]*TcpCheck, map[string]string) {
+	checks := make(map[string]*TcpCheck)
+	errs := make(map[string]string)
+	for _, tags := range list {
+		c, err := ParseTcpCheck(tags)
+		if err != nil {
+			errs[tags] = err.Error()
+			continue
+		}
+		checks[tags] = c
+	}
+	return checks, errs
+}
+
+// ParseDnsChecks parses a list of dns.resolve tags, returning the errors of
+// the invalid ones by tags
+func ParseDnsChecks(list []string) (map[string]*DnsCheck, map[string]string) {
+	checks := make(map[string]*DnsCheck)
+	errs := make(map[string]string)
+	for _, tags := range list {
+		c, err := ParseDnsCheck(tags)
+		if err != nil {
+			errs[tags] = err.Error()
+			continue
+		}
+		checks[tags] = c
+	}
+	return checks, errs
+}
+
+var (
+	localTcpChecks = newLocalChecks("collector.tcpConnects", func(c *CollectorConfig) (interface{}, map[string]string) {
+		return ParseTcpChecks(c.TcpConnects)
+	})
+	localDnsChecks = newLocalChecks("collector.dnsResolves", func(c *CollectorConfig) (interface{}, map[string]string) {
+		return ParseDnsChecks(c.DnsResolves)
+	})
+)
+
+// LocalNetChecks are the net.tcp.connect of collector.tcpConnects and the
+// dns.resolve of collector.dnsResolves
+func LocalNetChecks() (map[string]*TcpCheck, map[string]*DnsCheck) {
+	tcps, _ := localTcpChecks.get()
+	dnss, _ := localDnsChecks.get()
+	return tcps.(map[string]*TcpCheck), dnss.(map[string]*DnsCheck)
+}
@@ -0,0 +1,66 @@
+package g
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// PingCheck is a net.ping.loss, e.g. 'target=10.0.0.1,count=5,timeout=1'.
+// target=gateway pings the default gateway.
+type PingCheck struct {
+	Tags   string
+	Target string
+	Count  int
+	// of every echo, in seconds
+	Timeout int
+}
+
+// ParsePingCheck parses the tags of net.ping.loss
+func ParsePingCheck(tags string) (*PingCheck, error) {
+	m, err := ParseTags(tags, "target", "count", "timeout")
+	if err != nil {
+		return nil, err
+	}
+
+	c := &PingCheck{Tags: tags, Target: m["target"], Count: 5}
+	if c.Target == "" {
+		return nil, fmt.Errorf("target is needed")
+	}
+
+	if val, ok := m["count"]; ok {
+		if c.Count, err = strconv.Atoi(val); err != nil || c.Count <= 0 || c.Count > 100 {
+			return nil, fmt.Errorf("bad count %s", val)
+		}
+	}
+
+	if c.Timeout, err = parseTimeout(m, 1); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ParsePingChecks parses a list of net.ping.loss tags, returning the errors
+// of the invalid ones by tags
+func ParsePingChecks(list []string) (map[string]*PingCheck, map[string]string) {
+	checks := make(map[string]*PingCheck)
+	errs := make(map[string]string)
+	for _, tags := range list {
+		c, err := ParsePingCheck(tags)
+		if err != nil {
+			errs[tags] = err.Error()
+			continue
+		}
+		checks[tags] = c
+	}
+	return checks, errs
+}
+
+var localPings = newLocalChecks("collector.pings", func(c *CollectorConfig) (interface{}, map[string]string) {
+	return ParsePingChecks(c.Pings)
+})
+
+// LocalPings are the net.ping.loss of collector.pings
+func LocalPings() map[string]*PingCheck {
+	v, _ := localPings.get()
+	return v.(map[string]*PingCheck)
+}
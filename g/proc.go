@@ -0,0 +1,121 @@
+package g
+
+import (
+	"fmt"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ProcMatcher selects the processes of a proc.num, all of its conditions
+// must hold. Tags are e.g. 'name=nginx', 'cmdline=kafka.Kafka',
+// 'cmdline_regex=^java .*-Dapp=order', 'user=www', 'exe=/usr/sbin/nginx',
+// 'pidfile=/var/run/nginx.pid', 'unit=nginx.service', 'cgroup=/docker/'
+type ProcMatcher struct {
+	Tags         string
+	Name         string
+	Cmdline      string
+	CmdlineRegex *regexp.Regexp
+	// effective uid
+	Uid     string
+	Exe     string
+	Pidfile string
+	Unit    string
+	Cgroup  string
+}
+
+// ParseProcMatcher parses the tags of proc.num, a tag which is duplicated,
+// unknown or invalid is an error, so that a typo never widens the matcher.
+func ParseProcMatcher(tags string) (*ProcMatcher, error) {
+	kvs, err := SplitTags(tags)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(kvs) == 0 {
+		return nil, fmt.Errorf("no tag")
+	}
+
+	m := &ProcMatcher{Tags: tags}
+	for key, val := range kvs {
+		switch key {
+		case "name":
+			m.Name = val
+		case "cmdline":
+			m.Cmdline = val
+		case "cmdline_regex":
+			re, err := regexp.Compile(val)
+			if err != nil {
+				return nil, fmt.Errorf("bad cmdline_regex: %v", err)
+			}
+			m.CmdlineRegex = re
+		case "user":
+			uid, err := lookupUid(val)
+			if err != nil {
+				return nil, err
+			}
+			m.Uid = uid
+		case "exe":
+			if !filepath.IsAbs(val) {
+				return nil, fmt.Errorf("exe %s is not an absolute path", val)
+			}
+			m.Exe = val
+		case "pidfile":
+			if !filepath.IsAbs(val) {
+				return nil, fmt.Errorf("pidfile %s is not an absolute path", val)
+			}
+			m.Pidfile = val
+		case "unit":
+			if !strings.Contains(val, ".") {
+				val += ".service"
+			}
+			m.Unit = val
+		case "cgroup":
+			m.Cgroup = val
+		default:
+			return nil, fmt.Errorf("unknown tag %s", key)
+		}
+	}
+
+	return m, nil
+}
+
+func lookupUid(name string) (string, error) {
+	if _, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return name, nil
+	}
+
+	u, err := user.Lookup(name)
+	if err != nil {
+		return "", fmt.Errorf("unknown user %s", name)
+	}
+	return u.Uid, nil
+}
+
+// ParseProcMatchers parses a list of proc.num tags, returning the errors of
+// the invalid ones by tags
+func ParseProcMatchers(list []string) (map[string]*ProcMatcher, map[string]string) {
+	procs := make(map[string]*ProcMatcher)
+	errs := make(map[string]string)
+	for _, tags := range list {
+		m, err := ParseProcMatcher(tags)
+		if err != nil {
+			errs[tags] = err.Error()
+			continue
+		}
+		procs[tags] = m
+	}
+	return procs, errs
+}
+
+var localProcs = newLocalChecks("collector.procs", func(c *CollectorConfig) (interface{}, map[string]string) {
+	return ParseProcMatchers(c.Procs)
+})
+
+// LocalProcs are the proc.num of collector.procs
+func LocalProcs() map[string]*ProcMatcher {
+	v, _ := localProcs.get()
+	return v.(map[string]*ProcMatcher)
+}
@@ -0,0 +1,83 @@
+package g
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// SplitTags parses tags like 'k1=v1,k2=v2', a tag which is duplicated or
+// blank is an error
+func SplitTags(tags string) (map[string]string, error) {
+	m := make(map[string]string)
+	for _, kv := range strings.Split(tags, ",") {
+		arr := strings.SplitN(kv, "=", 2)
+		if len(arr) != 2 {
+			return nil, fmt.Errorf("bad tag %q", kv)
+		}
+
+		key := strings.TrimSpace(arr[0])
+		val := strings.TrimSpace(arr[1])
+		if key == "" {
+			return nil, fmt.Errorf("bad tag %q", kv)
+		}
+		if val == "" {
+			return nil, fmt.Errorf("blank %s", key)
+		}
+		if _, ok := m[key]; ok {
+			return nil, fmt.Errorf("duplicate %s", key)
+		}
+		m[key] = val
+	}
+	return m, nil
+}
+
+// ParseTags parses the tags of a builtin metric like SplitTags, a tag which
+// is not in keys is an error too
+func ParseTags(tags string, keys ...string) (map[string]string, error) {
+	m, err := SplitTags(tags)
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		known[key] = true
+	}
+	for key := range m {
+		if !known[key] {
+			return nil, fmt.Errorf("unknown tag %s", key)
+		}
+	}
+	return m, nil
+}
+
+// parseTimeout parses a timeout tag in seconds, def if not given
+func parseTimeout(m map[string]string, def int) (int, error) {
+	val, ok := m["timeout"]
+	if !ok {
+		return def, nil
+	}
+
+	timeout, err := strconv.Atoi(val)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("bad timeout %s", val)
+	}
+	return timeout, nil
+}
+
+// checkAddr checks an addr tag, host:port, returning the host
+func checkAddr(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	if host == "" {
+		return "", fmt.Errorf("blank host in %s", addr)
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return "", fmt.Errorf("bad port %s", port)
+	}
+	return host, nil
+}
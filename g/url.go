@@ -0,0 +1,172 @@
+package g
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// UrlCheck is a url.check.health. From heartbeat it is given by the tags
+// 'url=http://...,timeout=3[,method=GET][,codes=200|3xx][,body=regex]',
+// headers can be given by collector.urls only.
+type UrlCheck struct {
+	Url string `json:"url"`
+	// in seconds
+	Timeout int `json:"timeout"`
+	// HEAD if not given, or GET if there is a body regex
+	Method string `json:"method"`
+	// accepted status codes, e.g. 200 or 2xx, 200 if empty
+	Codes   []string          `json:"codes"`
+	Headers map[string]string `json:"headers"`
+	// regex the first 1MB of the body must match
+	Body      string         `json:"body"`
+	BodyRegex *regexp.Regexp `json:"-"`
+}
+
+// Accept tells whether a status code is one of Codes
+func (this *UrlCheck) Accept(code int) bool {
+	if len(this.Codes) == 0 {
+		return code == 200
+	}
+
+	s := strconv.Itoa(code)
+	for _, c := range this.Codes {
+		if c == s || (len(c) == 3 && strings.HasSuffix(c, "xx") && c[0] == s[0]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the fields and fills the defaults
+func (this *UrlCheck) Validate() error {
+	u, err := url.Parse(this.Url)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("bad url %s", this.Url)
+	}
+
+	if this.Timeout <= 0 {
+		return fmt.Errorf("bad timeout %d", this.Timeout)
+	}
+
+	this.Method = strings.ToUpper(this.Method)
+	// the probe sends no body
+	switch this.RequestMethod() {
+	case "GET", "HEAD", "OPTIONS":
+	default:
+		return fmt.Errorf("unsupported method %s", this.Method)
+	}
+	if this.Body != "" && this.RequestMethod() == "HEAD" {
+		return fmt.Errorf("no body to match with method HEAD")
+	}
+
+	for _, c := range this.Codes {
+		if len(c) != 3 {
+			return fmt.Errorf("bad code %s", c)
+		}
+		if strings.HasSuffix(c, "xx") {
+			c = c[:1]
+		}
+		if _, err := strconv.Atoi(c); err != nil {
+			return fmt.Errorf("bad code %s", c)
+		}
+	}
+
+	if this.Body != "" {
+		re, err := regexp.Compile(this.Body)
+		if err != nil {
+			return fmt.Errorf("bad body: %v", err)
+		}
+		this.BodyRegex = re
+	}
+
+	for k := range this.Headers {
+		if k == "" {
+			return fmt.Errorf("blank header")
+		}
+	}
+
+	return nil
+}
+
+// RequestMethod is the method of the probe, HEAD as curl -I did, unless
+// another one is given or the body is checked
+func (this *UrlCheck) RequestMethod() string {
+	if this.Method != "" {
+		return this.Method
+	}
+	if this.Body != "" {
+		return "GET"
+	}
+	return "HEAD"
+}
+
+// Tags of the metrics, those of heartbeat in the same order, so that a check
+// of the same url with another method, codes, body or Host header is another
+// series
+func (this *UrlCheck) Tags() string {
+	tags := fmt.Sprintf("url=%v,timeout=%v", this.Url, this.Timeout)
+	if this.Method != "" {
+		tags += ",method=" + this.Method
+	}
+	if len(this.Codes) > 0 {
+		tags += ",codes=" + strings.Join(this.Codes, "|")
+	}
+	if this.Body != "" {
+		tags += ",body=" + tagValueReplacer.Replace(this.Body)
+	}
+	if host := this.Headers["Host"]; host != "" {
+		tags += ",host=" + tagValueReplacer.Replace(host)
+	}
+	return tags
+}
+
+// a tag value can not have the separators of tags
+var tagValueReplacer = strings.NewReplacer(",", "_", "=", "_")
+
+// ParseUrlCheck parses the tags of url.check.health
+func ParseUrlCheck(tags string) (*UrlCheck, error) {
+	m, err := ParseTags(tags, "url", "timeout", "method", "codes", "body")
+	if err != nil {
+		return nil, err
+	}
+
+	c := &UrlCheck{Url: m["url"], Method: m["method"], Body: m["body"]}
+	if val, ok := m["timeout"]; ok {
+		if c.Timeout, err = strconv.Atoi(val); err != nil {
+			return nil, fmt.Errorf("bad timeout %s", val)
+		}
+	}
+	if val, ok := m["codes"]; ok {
+		c.Codes = strings.Split(val, "|")
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+var localUrls = newLocalChecks("collector.urls", func(c *CollectorConfig) (interface{}, map[string]string) {
+	checks := []*UrlCheck{}
+	errs := make(map[string]string)
+	for _, check := range c.Urls {
+		if err := check.Validate(); err != nil {
+			errs[check.Url] = err.Error()
+			continue
+		}
+		checks = append(checks, check)
+	}
+	return checks, errs
+})
+
+// LocalUrls are the valid checks of collector.urls
+func LocalUrls() []*UrlCheck {
+	v, _ := localUrls.get()
+	return v.([]*UrlCheck)
+}
@@ -70,34 +70,43 @@ func SendToTransfer(metrics []*model.MetricValue) {
 }
 
 var (
-	reportUrls     map[string]string
+	reportUrls     []*UrlCheck
 	reportUrlsLock = new(sync.RWMutex)
 )
 
-func ReportUrls() map[string]string {
+func ReportUrls() []*UrlCheck {
 	reportUrlsLock.RLock()
 	defer reportUrlsLock.RUnlock()
 	return reportUrls
 }
 
-func SetReportUrls(urls map[string]string) {
-	reportUrlsLock.RLock()
-	defer reportUrlsLock.RUnlock()
+func SetReportUrls(urls []*UrlCheck) {
+	reportUrlsLock.Lock()
+	defer reportUrlsLock.Unlock()
 	reportUrls = urls
 }
 
+// PortListen is a net.port.listen to check,
+// e.g. 'port=53,proto=udp,addr=10.0.0.1'=>{53, udp, 10.0.0.1}
+type PortListen struct {
+	Tags  string
+	Port  int64
+	Proto string
+	Addr  string
+}
+
 var (
-	reportPorts     []int64
+	reportPorts     []*PortListen
 	reportPortsLock = new(sync.RWMutex)
 )
 
-func ReportPorts() []int64 {
+func ReportPorts() []*PortListen {
 	reportPortsLock.RLock()
 	defer reportPortsLock.RUnlock()
 	return reportPorts
 }
 
-func SetReportPorts(ports []int64) {
+func SetReportPorts(ports []*PortListen) {
 	reportPortsLock.Lock()
 	defer reportPortsLock.Unlock()
 	reportPorts = ports
@@ -121,25 +130,112 @@ func SetDuPaths(paths []string) {
 }
 
 var (
-	// tags => {1=>name, 2=>cmdline}
-	// e.g. 'name=falcon-agent'=>{1=>falcon-agent}
-	// e.g. 'cmdline=xx'=>{2=>xx}
-	reportProcs     map[string]map[int]string
+	// tags => matcher, e.g. 'name=falcon-agent'=>{Name: falcon-agent}
+	reportProcs     map[string]*ProcMatcher
 	reportProcsLock = new(sync.RWMutex)
 )
 
-func ReportProcs() map[string]map[int]string {
+func ReportProcs() map[string]*ProcMatcher {
 	reportProcsLock.RLock()
 	defer reportProcsLock.RUnlock()
 	return reportProcs
 }
 
-func SetReportProcs(procs map[string]map[int]string) {
+func SetReportProcs(procs map[string]*ProcMatcher) {
 	reportProcsLock.Lock()
 	defer reportProcsLock.Unlock()
 	reportProcs = procs
 }
 
+var (
+	// metric => tags => why the builtin metric from hbs is invalid
+	reportErrors     map[string]map[string]string
+	reportErrorsLock = new(sync.RWMutex)
+)
+
+func ReportErrors() map[string]map[string]string {
+	reportErrorsLock.RLock()
+	defer reportErrorsLock.RUnlock()
+	return reportErrors
+}
+
+func SetReportErrors(errs map[string]map[string]string) {
+	reportErrorsLock.Lock()
+	defer reportErrorsLock.Unlock()
+	reportErrors = errs
+}
+
+var (
+	// tags => check, e.g. 'addr=example.com:443'=>{Addr: example.com:443}
+	reportCerts     map[string]*CertCheck
+	reportCertsLock = new(sync.RWMutex)
+)
+
+func ReportCerts() map[string]*CertCheck {
+	reportCertsLock.RLock()
+	defer reportCertsLock.RUnlock()
+	return reportCerts
+}
+
+func SetReportCerts(certs map[string]*CertCheck) {
+	reportCertsLock.Lock()
+	defer reportCertsLock.Unlock()
+	reportCerts = certs
+}
+
+var (
+	reportTcpChecks map[string]*TcpCheck
+	reportDnsChecks map[string]*DnsCheck
+	reportNetLock   = new(sync.RWMutex)
+)
+
+func ReportNetChecks() (map[string]*TcpCheck, map[string]*DnsCheck) {
+	reportNetLock.RLock()
+	defer reportNetLock.RUnlock()
+	return reportTcpChecks, reportDnsChecks
+}
+
+func SetReportNetChecks(tcps map[string]*TcpCheck, dnss map[string]*DnsCheck) {
+	reportNetLock.Lock()
+	defer reportNetLock.Unlock()
+	reportTcpChecks = tcps
+	reportDnsChecks = dnss
+}
+
+var (
+	reportPings     map[string]*PingCheck
+	reportPingsLock = new(sync.RWMutex)
+)
+
+func ReportPings() map[string]*PingCheck {
+	reportPingsLock.RLock()
+	defer reportPingsLock.RUnlock()
+	return reportPings
+}
+
+func SetReportPings(pings map[string]*PingCheck) {
+	reportPingsLock.Lock()
+	defer reportPingsLock.Unlock()
+	reportPings = pings
+}
+
+var (
+	reportFiles     map[string]*FileCheck
+	reportFilesLock = new(sync.RWMutex)
+)
+
+func ReportFiles() map[string]*FileCheck {
+	reportFilesLock.RLock()
+	defer reportFilesLock.RUnlock()
+	return reportFiles
+}
+
+func SetReportFiles(files map[string]*FileCheck) {
+	reportFilesLock.Lock()
+	defer reportFilesLock.Unlock()
+	reportFiles = files
+}
+
 var (
 	ips     []string
 	ipsLock = new(sync.Mutex)
@@ -23,6 +23,7 @@ func init() {
 	configMemoryRoutes()
 	configPageRoutes()
 	configPluginRoutes()
+	configProcRoutes()
 	configPushRoutes()
 	configRunRoutes()
 	configSystemRoutes()
@@ -67,4 +67,9 @@ func configPluginRoutes() {
 		//TODO: not thread safe
 		RenderDataJson(w, plugins.Plugins)
 	})
+
+	// manifests which can not be used, by path under plugin.dir
+	http.HandleFunc("/plugins/errors", func(w http.ResponseWriter, r *http.Request) {
+		RenderDataJson(w, plugins.ManifestErrors())
+	})
 }
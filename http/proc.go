@@ -0,0 +1,46 @@
+package http
+
+import (
+	"github.com/open-falcon/agent/funcs"
+	"github.com/open-falcon/agent/g"
+	"net/http"
+	"strconv"
+)
+
+func configProcRoutes() {
+	http.HandleFunc("/builtin/procs", func(w http.ResponseWriter, r *http.Request) {
+		matchers := funcs.AllProcMatchers()
+
+		tags := make([]string, 0, len(matchers))
+		for t := range matchers {
+			tags = append(tags, t)
+		}
+
+		RenderDataJson(w, map[string]interface{}{
+			"procs": tags,
+			"errors": map[string]interface{}{
+				"local": g.LocalErrors()["collector.procs"],
+				"hbs":   g.ReportErrors()[g.PROC_NUM],
+			},
+		})
+	})
+
+	http.HandleFunc("/builtin/errors", func(w http.ResponseWriter, r *http.Request) {
+		RenderDataJson(w, map[string]interface{}{
+			"local": g.LocalErrors(),
+			"hbs":   g.ReportErrors(),
+		})
+	})
+
+	http.HandleFunc("/proc/top", func(w http.ResponseWriter, r *http.Request) {
+		n := 10
+		if v, err := strconv.Atoi(r.FormValue("n")); err == nil && v > 0 {
+			n = v
+		}
+		if n > funcs.TopProcsMax {
+			n = funcs.TopProcsMax
+		}
+
+		RenderDataJson(w, funcs.LastTopProcs(n))
+	})
+}
@@ -0,0 +1,164 @@
+package plugins
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/open-falcon/agent/g"
+	"gopkg.in/yaml.v2"
+)
+
+// Manifest declares how to run a plugin. It is read from the sidecar of the
+// plugin, e.g. ntp.py.manifest.yaml, and from the manifest of the directory,
+// manifest.yaml, which has the manifests by plugin filename and the
+// defaults of the directory under "*". The sidecar wins over the directory.
+type Manifest struct {
+	Cycle int `json:"cycle" yaml:"cycle"`
+	// in milliseconds, cycle*1000-500 if not given
+	Timeout int               `json:"timeout" yaml:"timeout"`
+	Args    []string          `json:"args" yaml:"args"`
+	Env     map[string]string `json:"env" yaml:"env"`
+	// working directory, relative to the directory of the plugin
+	Dir  string `json:"dir" yaml:"dir"`
+	User string `json:"user" yaml:"user"`
+	// added to the metrics of the plugin, e.g. 'service=ntp'
+	Tags string `json:"tags" yaml:"tags"`
+}
+
+var manifestExts = []string{".json", ".yaml", ".yml"}
+
+// isManifest tells whether a file of a plugin directory is a manifest
+func isManifest(filename string) bool {
+	for _, ext := range manifestExts {
+		if filename == "manifest"+ext || strings.HasSuffix(filename, ".manifest"+ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// readManifest reads base.json, base.yaml or base.yml into v, the first one
+// found. It returns the path read, blank if there is none.
+func readManifest(base string, v interface{}) (string, int64, error) {
+	for _, ext := range manifestExts {
+		path := base + ext
+		fi, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+
+		bs, err := ioutil.ReadFile(path)
+		if err != nil {
+			return path, fi.ModTime().Unix(), err
+		}
+
+		if ext == ".json" {
+			err = json.Unmarshal(bs, v)
+		} else {
+			err = yaml.Unmarshal(bs, v)
+		}
+		return path, fi.ModTime().Unix(), err
+	}
+	return "", 0, nil
+}
+
+// merge sets the fields given in other
+func (this *Manifest) merge(other *Manifest) {
+	if other == nil {
+		return
+	}
+
+	if other.Cycle != 0 {
+		this.Cycle = other.Cycle
+	}
+	if other.Timeout != 0 {
+		this.Timeout = other.Timeout
+	}
+	if other.Args != nil {
+		this.Args = other.Args
+	}
+	if len(other.Env) > 0 {
+		env := make(map[string]string, len(this.Env)+len(other.Env))
+		for k, v := range this.Env {
+			env[k] = v
+		}
+		for k, v := range other.Env {
+			env[k] = v
+		}
+		this.Env = env
+	}
+	if other.Dir != "" {
+		this.Dir = other.Dir
+	}
+	if other.User != "" {
+		this.User = other.User
+	}
+	if other.Tags != "" {
+		this.Tags = other.Tags
+	}
+}
+
+// validate checks the manifest of a plugin run every cycle seconds, which
+// is the one of the manifest or else of the filename
+func (this *Manifest) validate(cycle int) error {
+	if this.Cycle < 0 {
+		return fmt.Errorf("bad cycle %d", this.Cycle)
+	}
+	if this.Timeout < 0 || (cycle > 0 && this.Timeout > cycle*1000) {
+		return fmt.Errorf("bad timeout %d, it is in milliseconds and no longer than the cycle", this.Timeout)
+	}
+
+	// or else running it fails every cycle
+	if this.User != "" {
+		if os.Geteuid() != 0 {
+			return fmt.Errorf("can not run as %s, the agent is not root", this.User)
+		}
+		if _, err := credentialOf(this.User); err != nil {
+			return fmt.Errorf("bad user %s: %v", this.User, err)
+		}
+	}
+
+	if this.Tags != "" {
+		if _, err := g.SplitTags(this.Tags); err != nil {
+			return fmt.Errorf("bad tags: %v", err)
+		}
+	}
+
+	for k := range this.Env {
+		if k == "" || strings.Contains(k, "=") {
+			return fmt.Errorf("bad env %q", k)
+		}
+	}
+
+	if filepath.IsAbs(this.Dir) {
+		return nil
+	}
+	if strings.HasPrefix(filepath.Clean(this.Dir), "..") {
+		return fmt.Errorf("dir %s is out of the plugin directory", this.Dir)
+	}
+	return nil
+}
+
+var (
+	// manifest => why it can not be used
+	manifestErrors     = make(map[string]string)
+	manifestErrorsLock = new(sync.RWMutex)
+)
+
+// ManifestErrors are the errors of the manifests of the last sync
+func ManifestErrors() map[string]string {
+	manifestErrorsLock.RLock()
+	defer manifestErrorsLock.RUnlock()
+	return manifestErrors
+}
+
+func SetManifestErrors(errs map[string]string) {
+	manifestErrorsLock.Lock()
+	defer manifestErrorsLock.Unlock()
+	manifestErrors = errs
+}
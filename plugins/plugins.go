@@ -2,8 +2,16 @@ package plugins
 
 type Plugin struct {
 	FilePath string
-	MTime    int64
-	Cycle    int
+	// the latest of the plugin and its manifests
+	MTime int64
+	Cycle int
+	// from the manifests, see Manifest
+	Timeout int
+	Args    []string
+	Env     map[string]string
+	Dir     string
+	User    string
+	Tags    string
 }
 
 var (
@@ -11,22 +11,33 @@ import (
 )
 
 // key: sys/ntp/60_ntp.py
-func ListPlugins(relativePath string) map[string]*Plugin {
+// A file is a plugin if its filename is $cycle_$xx, or if it has a
+// manifest. The errors of the manifests are returned by manifest.
+func ListPlugins(relativePath string) (map[string]*Plugin, map[string]string) {
 	ret := make(map[string]*Plugin)
+	errs := make(map[string]string)
 	if relativePath == "" {
-		return ret
+		return ret, errs
 	}
 
 	dir := filepath.Join(g.Config().Plugin.Dir, relativePath)
 
 	if !file.IsExist(dir) || file.IsFile(dir) {
-		return ret
+		return ret, errs
 	}
 
 	fs, err := ioutil.ReadDir(dir)
 	if err != nil {
 		log.Println("can not list files under", dir)
-		return ret
+		return ret, errs
+	}
+
+	dirManifests := make(map[string]*Manifest)
+	dirManifest, dirMTime, err := readManifest(filepath.Join(dir, "manifest"), &dirManifests)
+	if err != nil {
+		log.Println("parse", dirManifest, "fail:", err)
+		errs[filepath.Join(relativePath, filepath.Base(dirManifest))] = err.Error()
+		dirManifests = make(map[string]*Manifest)
 	}
 
 	for _, f := range fs {
@@ -35,22 +46,97 @@ func ListPlugins(relativePath string) map[string]*Plugin {
 		}
 
 		filename := f.Name()
-		arr := strings.Split(filename, "_")
-		if len(arr) < 2 {
+		if isManifest(filename) {
 			continue
 		}
 
-		// filename should be: $cycle_$xx
-		var cycle int
-		cycle, err = strconv.Atoi(arr[0])
+		m := &Manifest{}
+		m.merge(dirManifests["*"])
+		m.merge(dirManifests[filename])
+		manifest := dirManifest
+		_, hasManifest := dirManifests[filename]
+
+		var sidecar Manifest
+		sidecarPath, sidecarMTime, err := readManifest(filepath.Join(dir, filename+".manifest"), &sidecar)
+		if sidecarPath != "" {
+			hasManifest = true
+			manifest = sidecarPath
+		}
 		if err != nil {
+			log.Println("parse", sidecarPath, "fail:", err)
+			addError(errs, filepath.Join(relativePath, filepath.Base(sidecarPath)), err.Error())
+		} else {
+			m.merge(&sidecar)
+		}
+
+		// filename should be: $cycle_$xx, unless it has a manifest
+		nameCycle, ok := cycleOfFilename(filename)
+		if !ok && !hasManifest {
+			continue
+		}
+
+		cycle := m.Cycle
+		if cycle == 0 {
+			cycle = nameCycle
+		}
+
+		if err := m.validate(cycle); err != nil {
+			// fall back to the filename
+			log.Println("manifest of", filename, "in", manifest, "is invalid:", err)
+			addError(errs, filepath.Join(relativePath, filepath.Base(manifest)), filename+": "+err.Error())
+			m = &Manifest{}
+			cycle = nameCycle
+		}
+
+		if cycle <= 0 {
+			addError(errs, filepath.Join(relativePath, filepath.Base(manifest)), filename+": no cycle")
 			continue
 		}
 
+		mtime := f.ModTime().Unix()
+		if dirMTime > mtime {
+			mtime = dirMTime
+		}
+		if sidecarMTime > mtime {
+			mtime = sidecarMTime
+		}
+
 		fpath := filepath.Join(relativePath, filename)
-		plugin := &Plugin{FilePath: fpath, MTime: f.ModTime().Unix(), Cycle: cycle}
+		plugin := &Plugin{
+			FilePath: fpath,
+			MTime:    mtime,
+			Cycle:    cycle,
+			Timeout:  m.Timeout,
+			Args:     m.Args,
+			Env:      m.Env,
+			Dir:      m.Dir,
+			User:     m.User,
+			Tags:     m.Tags,
+		}
 		ret[fpath] = plugin
 	}
 
-	return ret
+	return ret, errs
+}
+
+// addError keeps the errors of every plugin of a directory manifest
+func addError(errs map[string]string, manifest, err string) {
+	if errs[manifest] != "" {
+		err = errs[manifest] + "; " + err
+	}
+	errs[manifest] = err
+}
+
+// cycleOfFilename parses the cycle of $cycle_$xx
+func cycleOfFilename(filename string) (int, bool) {
+	arr := strings.Split(filename, "_")
+	if len(arr) < 2 {
+		return 0, false
+	}
+
+	cycle, err := strconv.Atoi(arr[0])
+	if err != nil {
+		return 0, false
+	}
+	return cycle, true
 }
@@ -4,8 +4,12 @@ import (
 	"bytes"
 	"encoding/json"
 	"log"
+	"os"
 	"os/exec"
+	"os/user"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -50,6 +54,9 @@ func (this *PluginScheduler) Stop() {
 func PluginRun(plugin *Plugin) {
 
 	timeout := plugin.Cycle*1000 - 500
+	if plugin.Timeout > 0 {
+		timeout = plugin.Timeout
+	}
 	fpath := filepath.Join(g.Config().Plugin.Dir, plugin.FilePath)
 
 	if !file.IsExist(fpath) {
@@ -62,13 +69,40 @@ func PluginRun(plugin *Plugin) {
 		log.Println(fpath, "running...")
 	}
 
-	cmd := exec.Command(fpath)
+	cmd := exec.Command(fpath, plugin.Args...)
 	var stdout bytes.Buffer
 	cmd.Stdout = &stdout
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
 	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
-	cmd.Start()
+
+	if plugin.Dir != "" {
+		cmd.Dir = plugin.Dir
+		if !filepath.IsAbs(cmd.Dir) {
+			cmd.Dir = filepath.Join(filepath.Dir(fpath), cmd.Dir)
+		}
+	}
+
+	if len(plugin.Env) > 0 {
+		cmd.Env = os.Environ()
+		for k, v := range plugin.Env {
+			cmd.Env = append(cmd.Env, k+"="+v)
+		}
+	}
+
+	if plugin.User != "" {
+		cred, err := credentialOf(plugin.User)
+		if err != nil {
+			log.Println("[ERROR] run plugin", fpath, "as", plugin.User, "fail. error:", err)
+			return
+		}
+		cmd.SysProcAttr.Credential = cred
+	}
+
+	if err := cmd.Start(); err != nil {
+		log.Println("[ERROR] start plugin", fpath, "fail. error:", err)
+		return
+	}
 
 	err, isTimeout := sys.CmdRunWithTimeout(cmd, time.Duration(timeout)*time.Millisecond)
 
@@ -114,5 +148,61 @@ func PluginRun(plugin *Plugin) {
 		return
 	}
 
+	if plugin.Tags != "" {
+		for _, m := range metrics {
+			m.Tags = addTags(m.Tags, plugin.Tags)
+		}
+	}
+
 	g.SendToTransfer(metrics)
 }
+
+// addTags adds the tags of the manifest whose keys are not in the tags of
+// the metric
+func addTags(tags, more string) string {
+	keys := make(map[string]bool)
+	for _, kv := range strings.Split(tags, ",") {
+		if arr := strings.SplitN(kv, "=", 2); len(arr) == 2 {
+			keys[strings.TrimSpace(arr[0])] = true
+		}
+	}
+
+	for _, kv := range strings.Split(more, ",") {
+		arr := strings.SplitN(kv, "=", 2)
+		if len(arr) != 2 || keys[strings.TrimSpace(arr[0])] {
+			continue
+		}
+		if tags == "" {
+			tags = strings.TrimSpace(kv)
+		} else {
+			tags += "," + strings.TrimSpace(kv)
+		}
+	}
+	return tags
+}
+
+func credentialOf(name string) (*syscall.Credential, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	cred := &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+	if gids, err := u.GroupIds(); err == nil {
+		for _, s := range gids {
+			if v, err := strconv.ParseUint(s, 10, 32); err == nil {
+				cred.Groups = append(cred.Groups, uint32(v))
+			}
+		}
+	}
+	return cred, nil
+}